@@ -0,0 +1,98 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+package factory
+
+import (
+	"os"
+	"plugin"
+	"reflect"
+
+	"github.com/hyperledger/fabric/bccsp"
+	"github.com/hyperledger/fabric/bccsp/sw"
+	"github.com/pkg/errors"
+)
+
+// PluginKeystoreOpts contains the options for loading a KeyStore from a plugin
+type PluginKeystoreOpts struct {
+	// Path to plugin library
+	Library string `mapstructure:"library" json:"library" yaml:"Library"`
+	// Config map for the plugin library
+	Config map[string]interface{} `mapstructure:"config,omitempty" json:"config,omitempty" yaml:"Config"`
+}
+
+// SigningKeyStore is implemented by KeyStores which keep private keys in an
+// external device or service, such as an HSM or a KMS. The keys returned by
+// GetKey are then handles whose signing is delegated back to the KeyStore.
+type SigningKeyStore interface {
+	bccsp.KeyStore
+
+	// Sign signs digest using key k.
+	// The opts argument should be appropriate for the algorithm used.
+	Sign(k bccsp.Key, digest []byte, opts bccsp.SignerOpts) (signature []byte, err error)
+
+	// KeyTypes returns the types of the keys Sign accepts.
+	KeyTypes() []reflect.Type
+}
+
+// loadKeyStorePlugin opens the plugin library and returns the KeyStore
+// created by its 'NewKeyStore' symbol.
+func loadKeyStorePlugin(opts *PluginKeystoreOpts) (bccsp.KeyStore, error) {
+	// Library is required property
+	if opts.Library == "" {
+		return nil, errors.New("Invalid config: missing property 'Library'")
+	}
+
+	// make sure the library exists
+	if _, err := os.Stat(opts.Library); err != nil {
+		return nil, errors.Errorf("Could not find library '%s' [%s]", opts.Library, err)
+	}
+
+	// attempt to load the library as a plugin
+	plug, err := plugin.Open(opts.Library)
+	if err != nil {
+		return nil, errors.Errorf("Failed to load plugin '%s' [%s]", opts.Library, err)
+	}
+
+	// lookup the required symbol 'NewKeyStore'
+	sym, err := plug.Lookup("NewKeyStore")
+	if err != nil {
+		return nil, errors.Errorf("Could not find required symbol 'NewKeyStore' [%s]", err)
+	}
+
+	// check to make sure symbol NewKeyStore meets the required function signature
+	newKeyStore, ok := sym.(func(config map[string]interface{}) (bccsp.KeyStore, error))
+	if !ok {
+		return nil, errors.New("Plugin does not implement the required function signature for 'NewKeyStore'")
+	}
+
+	ks, err := newKeyStore(opts.Config)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to create key store from plugin '%s'", opts.Library)
+	}
+	if ks == nil {
+		return nil, errors.Errorf("Plugin '%s' returned a nil key store", opts.Library)
+	}
+	return ks, nil
+}
+
+// addKeyStoreSigners delegates signing with the keys of a SigningKeyStore
+// to the KeyStore.
+func addKeyStoreSigners(csp bccsp.BCCSP, ks bccsp.KeyStore) error {
+	sks, ok := ks.(SigningKeyStore)
+	if !ok {
+		return nil
+	}
+	swcsp, ok := csp.(*sw.CSP)
+	if !ok {
+		return errors.Errorf("Unexpected BCCSP type [%T]", csp)
+	}
+	for _, t := range sks.KeyTypes() {
+		if err := swcsp.AddWrapper(t, sks); err != nil {
+			return errors.Wrapf(err, "Failed registering key store signer for [%s]", t)
+		}
+	}
+	return nil
+}
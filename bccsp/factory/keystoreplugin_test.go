@@ -0,0 +1,102 @@
+// +build go1.9,linux,cgo go1.10,darwin,cgo
+// +build !ppc64le
+
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+package factory
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/hyperledger/fabric/bccsp"
+	"github.com/stretchr/testify/assert"
+)
+
+func buildKeyStorePlugin(lib string, t *testing.T) {
+	t.Helper()
+	// check to see if the example plugin exists
+	if _, err := os.Stat(lib); err != nil {
+		// build the example plugin
+		cmd := exec.Command("go", "build", "-buildmode=plugin", "-o", lib)
+		if raceEnabled {
+			cmd.Args = append(cmd.Args, "-race")
+		}
+		cmd.Args = append(cmd.Args, "github.com/hyperledger/fabric/examples/plugins/keystore")
+		err := cmd.Run()
+		if err != nil {
+			t.Fatalf("Could not build plugin: [%s]", err)
+		}
+	}
+}
+
+func TestSWFactoryGetPluginKeystore(t *testing.T) {
+	// build plugin
+	lib := "./keystore.so"
+	defer os.Remove(lib)
+	buildKeyStorePlugin(lib, t)
+
+	f := &SWFactory{}
+	opts := &FactoryOpts{
+		SwOpts: &SwOpts{
+			SecLevel:       256,
+			HashFamily:     "SHA2",
+			PluginKeystore: &PluginKeystoreOpts{Library: lib},
+		},
+	}
+
+	csp, err := f.Get(opts)
+	assert.NoError(t, err)
+	assert.NotNil(t, csp)
+
+	_, err = csp.GetKey([]byte{123})
+	assert.Error(t, err)
+}
+
+func TestSWFactoryPluginKeystorePrecedence(t *testing.T) {
+	lib := "./keystore.so"
+	defer os.Remove(lib)
+	buildKeyStorePlugin(lib, t)
+
+	keyStorePath, err := ioutil.TempDir("", "keystore")
+	assert.NoError(t, err)
+	defer os.RemoveAll(keyStorePath)
+
+	f := &SWFactory{}
+	opts := &FactoryOpts{
+		SwOpts: &SwOpts{
+			SecLevel:       256,
+			HashFamily:     "SHA2",
+			FileKeystore:   &FileKeystoreOpts{KeyStorePath: keyStorePath},
+			PluginKeystore: &PluginKeystoreOpts{Library: lib},
+		},
+	}
+
+	csp, err := f.Get(opts)
+	assert.NoError(t, err)
+
+	// the example plugin is read only, the file key store would accept the key
+	_, err = csp.KeyGen(&bccsp.ECDSAP256KeyGenOpts{Temporary: false})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "read only key store")
+
+	files, err := ioutil.ReadDir(keyStorePath)
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestLoadKeyStorePluginMissingSymbol(t *testing.T) {
+	// the BCCSP example plugin does not export NewKeyStore
+	lib := "./bccsp.so"
+	defer os.Remove(lib)
+	buildPlugin(lib, t)
+
+	_, err := loadKeyStorePlugin(&PluginKeystoreOpts{Library: lib})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Could not find required symbol 'NewKeyStore'")
+}
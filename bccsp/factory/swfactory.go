@@ -46,6 +46,12 @@ func (f *SWFactory) Get(config *FactoryOpts) (bccsp.BCCSP, error) {
 	var ks bccsp.KeyStore
 	if swOpts.Ephemeral == true {
 		ks = sw.NewDummyKeyStore()
+	} else if swOpts.PluginKeystore != nil {
+		pks, err := loadKeyStorePlugin(swOpts.PluginKeystore)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to initialize plugin key store")
+		}
+		ks = pks
 	} else if swOpts.FileKeystore != nil {
 		fks, err := sw.NewFileBasedKeyStore(nil, swOpts.FileKeystore.KeyStorePath, false)
 		if err != nil {
@@ -59,7 +65,14 @@ func (f *SWFactory) Get(config *FactoryOpts) (bccsp.BCCSP, error) {
 		ks = sw.NewDummyKeyStore()
 	}
 
-	return sw.NewWithParams(swOpts.SecLevel, swOpts.HashFamily, ks)
+	csp, err := sw.NewWithParams(swOpts.SecLevel, swOpts.HashFamily, ks)
+	if err != nil {
+		return nil, err
+	}
+	if err := addKeyStoreSigners(csp, ks); err != nil {
+		return nil, err
+	}
+	return csp, nil
 }
 
 // SwOpts contains options for the SWFactory
@@ -73,6 +86,9 @@ type SwOpts struct {
 	FileKeystore  *FileKeystoreOpts  `mapstructure:"filekeystore,omitempty" json:"filekeystore,omitempty" yaml:"FileKeyStore"`
 	DummyKeystore *DummyKeystoreOpts `mapstructure:"dummykeystore,omitempty" json:"dummykeystore,omitempty"`
 	InmemKeystore *InmemKeystoreOpts `mapstructure:"inmemkeystore,omitempty" json:"inmemkeystore,omitempty"`
+	// PluginKeystore loads the KeyStore from a Go plugin library, taking
+	// precedence over FileKeystore
+	PluginKeystore *PluginKeystoreOpts `mapstructure:"pluginkeystore,omitempty" json:"pluginkeystore,omitempty" yaml:"PluginKeyStore"`
 }
 
 // Pluggable Keystores, could add JKS, P12, etc..
@@ -17,8 +17,12 @@ package factory
 
 import (
 	"os"
+	"reflect"
 	"testing"
 
+	"github.com/hyperledger/fabric/bccsp"
+	"github.com/hyperledger/fabric/bccsp/mocks"
+	"github.com/hyperledger/fabric/bccsp/sw"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -68,3 +72,58 @@ func TestSWFactoryGet(t *testing.T) {
 	assert.NotNil(t, csp)
 
 }
+
+type signingKeyStore struct {
+	bccsp.KeyStore
+	signed []byte
+}
+
+func (ks *signingKeyStore) Sign(k bccsp.Key, digest []byte, opts bccsp.SignerOpts) ([]byte, error) {
+	ks.signed = digest
+	return []byte("signature"), nil
+}
+
+func (ks *signingKeyStore) KeyTypes() []reflect.Type {
+	return []reflect.Type{reflect.TypeOf(&mocks.MockKey{})}
+}
+
+func TestSWFactoryGetPluginKeystoreMissingLibrary(t *testing.T) {
+	f := &SWFactory{}
+
+	opts := &FactoryOpts{
+		SwOpts: &SwOpts{
+			SecLevel:       256,
+			HashFamily:     "SHA2",
+			PluginKeystore: &PluginKeystoreOpts{},
+		},
+	}
+	_, err := f.Get(opts)
+	assert.EqualError(t, err, "Failed to initialize plugin key store: Invalid config: missing property 'Library'")
+
+	opts.SwOpts.PluginKeystore.Library = "notexist.so"
+	_, err = f.Get(opts)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Could not find library 'notexist.so'")
+}
+
+func TestAddKeyStoreSigners(t *testing.T) {
+	ks := &signingKeyStore{KeyStore: sw.NewInMemoryKeyStore()}
+	csp, err := sw.NewWithParams(256, "SHA2", ks)
+	assert.NoError(t, err)
+
+	_, err = csp.Sign(&mocks.MockKey{}, []byte("digest"), nil)
+	assert.Error(t, err)
+
+	err = addKeyStoreSigners(csp, ks)
+	assert.NoError(t, err)
+	signature, err := csp.Sign(&mocks.MockKey{}, []byte("digest"), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("signature"), signature)
+	assert.Equal(t, []byte("digest"), ks.signed)
+
+	err = addKeyStoreSigners(&mocks.MockBCCSP{}, ks)
+	assert.EqualError(t, err, "Unexpected BCCSP type [*mocks.MockBCCSP]")
+
+	err = addKeyStoreSigners(&mocks.MockBCCSP{}, sw.NewInMemoryKeyStore())
+	assert.NoError(t, err)
+}
@@ -0,0 +1,147 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package crypto
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/common/metrics"
+	"github.com/hyperledger/fabric/protos/msp"
+)
+
+var daysUntilExpirationOpts = metrics.GaugeOpts{
+	Namespace:    "certificate",
+	Name:         "days_until_expiration",
+	Help:         "The number of days until the certificate expires, negative once expired.",
+	LabelNames:   []string{"role", "id"},
+	StatsdFormat: "%{#fqname}.%{role}.%{id}",
+}
+
+// RoleCertificate is a PEM encoded certificate and the role it serves.
+type RoleCertificate struct {
+	Role string
+	// ID tells apart the certificates of a role. It does not change when the
+	// certificate is renewed, so the renewed certificate replaces the series
+	// of the old one.
+	ID   string
+	Cert []byte
+	// File, when set, is read on every report in place of Cert so that
+	// certificates reloaded from disk are reported once renewed. Cert is
+	// reported while the file cannot be read.
+	File string
+}
+
+// ReloadedFrom sets the file the certificates of the role are reloaded from.
+func ReloadedFrom(certs []RoleCertificate, role, file string) {
+	for i := range certs {
+		if certs[i].Role == role {
+			certs[i].File = file
+		}
+	}
+}
+
+// ExpirationCertificates returns the certificates TrackExpiration warns about,
+// labeled by their role and identified by the MSP ID of the signing identity.
+func ExpirationCertificates(tls bool, serverCert []byte, clientCertChain [][]byte, sIDBytes []byte) []RoleCertificate {
+	var certs []RoleCertificate
+
+	sID := &msp.SerializedIdentity{}
+	if err := proto.Unmarshal(sIDBytes, sID); err == nil {
+		certs = append(certs, RoleCertificate{Role: "enrollment", ID: sID.Mspid, Cert: sID.IdBytes})
+	}
+
+	if !tls {
+		return certs
+	}
+
+	certs = append(certs, RoleCertificate{Role: "server_tls", ID: sID.Mspid, Cert: serverCert})
+	if len(clientCertChain) > 0 && len(clientCertChain[0]) > 0 {
+		certs = append(certs, RoleCertificate{Role: "client_tls", ID: sID.Mspid, Cert: clientCertChain[0]})
+	}
+	return certs
+}
+
+// MSPCACertificates returns the CA certificates of a fabric MSP
+// configuration, labeled by their role and identified by the MSP name and
+// their position.
+func MSPCACertificates(conf *msp.MSPConfig) ([]RoleCertificate, error) {
+	fabricConf := &msp.FabricMSPConfig{}
+	if err := proto.Unmarshal(conf.Config, fabricConf); err != nil {
+		return nil, err
+	}
+
+	var certs []RoleCertificate
+	add := func(role string, pems [][]byte) {
+		for i, cert := range pems {
+			certs = append(certs, RoleCertificate{Role: role, ID: fmt.Sprintf("%s-%d", fabricConf.Name, i), Cert: cert})
+		}
+	}
+	add("ca", fabricConf.RootCerts)
+	add("intermediate_ca", fabricConf.IntermediateCerts)
+	add("tls_ca", fabricConf.TlsRootCerts)
+	add("tls_intermediate_ca", fabricConf.TlsIntermediateCerts)
+	return certs, nil
+}
+
+// ExpirationReporter publishes the number of days until each of its
+// certificates expires.
+type ExpirationReporter struct {
+	DaysUntilExpiration metrics.Gauge
+	Certificates        []RoleCertificate
+}
+
+// NewExpirationReporter creates an ExpirationReporter for the certificates.
+func NewExpirationReporter(p metrics.Provider, certs []RoleCertificate) *ExpirationReporter {
+	return &ExpirationReporter{
+		DaysUntilExpiration: p.NewGauge(daysUntilExpirationOpts),
+		Certificates:        certs,
+	}
+}
+
+// Report sets the gauge of each certificate relative to now. Certificates
+// which cannot be parsed are skipped.
+func (r *ExpirationReporter) Report(now time.Time) {
+	for _, rc := range r.Certificates {
+		pemBytes := rc.Cert
+		if rc.File != "" {
+			if b, err := ioutil.ReadFile(rc.File); err == nil {
+				pemBytes = b
+			}
+		}
+		bl, _ := pem.Decode(pemBytes)
+		if bl == nil {
+			continue
+		}
+		cert, err := x509.ParseCertificate(bl.Bytes)
+		if err != nil {
+			continue
+		}
+		days := cert.NotAfter.Sub(now).Hours() / 24
+		r.DaysUntilExpiration.With("role", rc.Role, "id", rc.ID).Set(days)
+	}
+}
+
+// Run reports immediately and then every interval until stop is closed.
+func (r *ExpirationReporter) Run(interval time.Duration, stop <-chan struct{}) {
+	r.Report(time.Now())
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			r.Report(now)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package crypto_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/common/crypto"
+	"github.com/hyperledger/fabric/common/crypto/tlsgen"
+	"github.com/hyperledger/fabric/common/metrics/metricsfakes"
+	"github.com/hyperledger/fabric/protos/msp"
+	"github.com/hyperledger/fabric/protos/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpirationCertificates(t *testing.T) {
+	signingIdentity := utils.MarshalOrPanic(&msp.SerializedIdentity{Mspid: "Org1MSP", IdBytes: []byte("enrollment")})
+
+	certs := crypto.ExpirationCertificates(false, []byte("server"), nil, signingIdentity)
+	assert.Equal(t, []crypto.RoleCertificate{{Role: "enrollment", ID: "Org1MSP", Cert: []byte("enrollment")}}, certs)
+
+	certs = crypto.ExpirationCertificates(true, []byte("server"), [][]byte{[]byte("client")}, signingIdentity)
+	assert.Equal(t, []crypto.RoleCertificate{
+		{Role: "enrollment", ID: "Org1MSP", Cert: []byte("enrollment")},
+		{Role: "server_tls", ID: "Org1MSP", Cert: []byte("server")},
+		{Role: "client_tls", ID: "Org1MSP", Cert: []byte("client")},
+	}, certs)
+
+	certs = crypto.ExpirationCertificates(true, []byte("server"), nil, []byte{1, 2, 3})
+	assert.Equal(t, []crypto.RoleCertificate{{Role: "server_tls", Cert: []byte("server")}}, certs)
+}
+
+func TestMSPCACertificates(t *testing.T) {
+	conf := &msp.MSPConfig{
+		Config: utils.MarshalOrPanic(&msp.FabricMSPConfig{
+			Name:                 "Org1MSP",
+			RootCerts:            [][]byte{[]byte("root")},
+			IntermediateCerts:    [][]byte{[]byte("intermediate1"), []byte("intermediate2")},
+			TlsRootCerts:         [][]byte{[]byte("tlsroot")},
+			TlsIntermediateCerts: [][]byte{[]byte("tlsintermediate")},
+		}),
+	}
+	certs, err := crypto.MSPCACertificates(conf)
+	assert.NoError(t, err)
+	assert.Equal(t, []crypto.RoleCertificate{
+		{Role: "ca", ID: "Org1MSP-0", Cert: []byte("root")},
+		{Role: "intermediate_ca", ID: "Org1MSP-0", Cert: []byte("intermediate1")},
+		{Role: "intermediate_ca", ID: "Org1MSP-1", Cert: []byte("intermediate2")},
+		{Role: "tls_ca", ID: "Org1MSP-0", Cert: []byte("tlsroot")},
+		{Role: "tls_intermediate_ca", ID: "Org1MSP-0", Cert: []byte("tlsintermediate")},
+	}, certs)
+
+	_, err = crypto.MSPCACertificates(&msp.MSPConfig{Config: []byte{1, 2, 3}})
+	assert.Error(t, err)
+}
+
+func TestExpirationReporter(t *testing.T) {
+	ca, err := tlsgen.NewCA()
+	assert.NoError(t, err)
+	bl, _ := pem.Decode(ca.CertBytes())
+	caCert, err := x509.ParseCertificate(bl.Bytes)
+	assert.NoError(t, err)
+
+	fakeProvider := &metricsfakes.Provider{}
+	fakeGauge := &metricsfakes.Gauge{}
+	fakeGauge.WithReturns(fakeGauge)
+	fakeProvider.NewGaugeReturns(fakeGauge)
+
+	reporter := crypto.NewExpirationReporter(fakeProvider, []crypto.RoleCertificate{
+		{Role: "bad", Cert: []byte("not a certificate")},
+		{Role: "ca", ID: "Org1MSP-0", Cert: ca.CertBytes()},
+	})
+	assert.Equal(t, 1, fakeProvider.NewGaugeCallCount())
+
+	reporter.Report(caCert.NotAfter.Add(-36 * time.Hour))
+	assert.Equal(t, 1, fakeGauge.WithCallCount())
+	assert.Equal(t, []string{"role", "ca", "id", "Org1MSP-0"}, fakeGauge.WithArgsForCall(0))
+	assert.Equal(t, 1, fakeGauge.SetCallCount())
+	assert.Equal(t, 1.5, fakeGauge.SetArgsForCall(0))
+
+	reporter.Report(caCert.NotAfter.Add(24 * time.Hour))
+	assert.Equal(t, -1.0, fakeGauge.SetArgsForCall(1))
+
+	stop := make(chan struct{})
+	close(stop)
+	reporter.Run(time.Hour, stop)
+	assert.Equal(t, 3, fakeGauge.SetCallCount())
+}
+
+// selfSignedCert returns a PEM encoded certificate for the common name
+// expiring after validity.
+func selfSignedCert(t *testing.T, cn string, validity time.Duration) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(validity),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestExpirationReporterReloadedFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "expirationmetrics")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	certFile := filepath.Join(dir, "server.crt")
+
+	fakeProvider := &metricsfakes.Provider{}
+	fakeGauge := &metricsfakes.Gauge{}
+	fakeGauge.WithReturns(fakeGauge)
+	fakeProvider.NewGaugeReturns(fakeGauge)
+
+	certs := []crypto.RoleCertificate{
+		{Role: "server_tls", ID: "Org1MSP", Cert: selfSignedCert(t, "peer0", 24*time.Hour)},
+		{Role: "ca", ID: "Org1MSP-0", Cert: selfSignedCert(t, "ca", 240*time.Hour)},
+		{Role: "ca", ID: "Org1MSP-1", Cert: selfSignedCert(t, "ca", 480*time.Hour)},
+	}
+	crypto.ReloadedFrom(certs, "server_tls", certFile)
+	assert.Equal(t, certFile, certs[0].File)
+	assert.Empty(t, certs[1].File)
+	reporter := crypto.NewExpirationReporter(fakeProvider, certs)
+
+	// the certificate loaded at startup is reported while the file is missing
+	reporter.Report(time.Now())
+	assert.Equal(t, []string{"role", "server_tls", "id", "Org1MSP"}, fakeGauge.WithArgsForCall(0))
+	assert.InDelta(t, 1.0, fakeGauge.SetArgsForCall(0), 0.01)
+
+	// certificates with the same subject are reported separately
+	assert.Equal(t, []string{"role", "ca", "id", "Org1MSP-0"}, fakeGauge.WithArgsForCall(1))
+	assert.Equal(t, []string{"role", "ca", "id", "Org1MSP-1"}, fakeGauge.WithArgsForCall(2))
+
+	// the renewed certificate replaces the series of the initial one
+	err = ioutil.WriteFile(certFile, selfSignedCert(t, "peer0", 48*time.Hour), 0600)
+	assert.NoError(t, err)
+	reporter.Report(time.Now())
+	assert.Equal(t, []string{"role", "server_tls", "id", "Org1MSP"}, fakeGauge.WithArgsForCall(3))
+	assert.InDelta(t, 2.0, fakeGauge.SetArgsForCall(3), 0.01)
+}
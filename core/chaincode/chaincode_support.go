@@ -14,6 +14,7 @@ import (
 	"github.com/hyperledger/fabric/common/metrics"
 	"github.com/hyperledger/fabric/common/util"
 	"github.com/hyperledger/fabric/core/chaincode/platforms"
+	"github.com/hyperledger/fabric/core/chaincode/relaunch"
 	"github.com/hyperledger/fabric/core/common/ccprovider"
 	"github.com/hyperledger/fabric/core/common/sysccprovider"
 	"github.com/hyperledger/fabric/core/container/ccintf"
@@ -58,6 +59,7 @@ type ChaincodeSupport struct {
 	appConfig        ApplicationConfigRetriever
 	HandlerMetrics   *HandlerMetrics
 	LaunchMetrics    *LaunchMetrics
+	launched         relaunch.Chaincodes
 }
 
 // NewChaincodeSupport creates a new ChaincodeSupport instance.
@@ -127,7 +129,12 @@ func (cs *ChaincodeSupport) LaunchInit(ccci *ccprovider.ChaincodeContainerInfo)
 		return nil
 	}
 
-	return cs.Launcher.Launch(ccci)
+	if err := cs.Launcher.Launch(ccci); err != nil {
+		cs.launched.Remove(cname)
+		return err
+	}
+	cs.launched.Add(ccci)
+	return nil
 }
 
 // Launch starts executing chaincode if it is not already running. This method
@@ -152,8 +159,10 @@ func (cs *ChaincodeSupport) Launch(chainID, chaincodeName, chaincodeVersion stri
 	}
 
 	if err := cs.Launcher.Launch(ccci); err != nil {
+		cs.launched.Remove(cname)
 		return nil, errors.Wrapf(err, "[channel %s] could not launch chaincode %s", chainID, cname)
 	}
+	cs.launched.Add(ccci)
 
 	h := cs.HandlerRegistry.Handler(cname)
 	if h == nil {
@@ -165,6 +174,7 @@ func (cs *ChaincodeSupport) Launch(chainID, chaincodeName, chaincodeVersion stri
 
 // Stop stops a chaincode if running.
 func (cs *ChaincodeSupport) Stop(ccci *ccprovider.ChaincodeContainerInfo) error {
+	cs.launched.Remove(ccci.Name + ":" + ccci.Version)
 	return cs.Runtime.Stop(ccci)
 }
 
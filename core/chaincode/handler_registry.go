@@ -121,6 +121,11 @@ func (r *HandlerRegistry) Handler(cname string) *Handler {
 	return h
 }
 
+// Connected returns true if a handler is registered for the chaincode instance.
+func (r *HandlerRegistry) Connected(cname string) bool {
+	return r.Handler(cname) != nil
+}
+
 // Register adds a chaincode handler to the registry.
 // An error will be returned if a handler is already registered for the
 // chaincode. An error will also be returned if the chaincode has not already
@@ -0,0 +1,17 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+// Relaunch launches a chaincode previously launched by the peer again once
+// its running instance has disconnected. Container controllers use it to move
+// chaincode off machines which are being reclaimed, so the chaincode is
+// available again before its next invocation.
+func (cs *ChaincodeSupport) Relaunch(chaincodeName, chaincodeVersion string) error {
+	cname := chaincodeName + ":" + chaincodeVersion
+	chaincodeLogger.Infof("Relaunching chaincode %s", cname)
+	return cs.launched.Relaunch(cname, cs.HandlerRegistry, cs.Launcher)
+}
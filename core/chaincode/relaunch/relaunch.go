@@ -0,0 +1,103 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package relaunch
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric/core/common/ccprovider"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultPollInterval is how often Relaunch checks whether the running
+	// instance of the chaincode has disconnected.
+	DefaultPollInterval = 100 * time.Millisecond
+
+	// DefaultDisconnectTimeout bounds the wait for the running instance of
+	// the chaincode to disconnect.
+	DefaultDisconnectTimeout = time.Minute
+)
+
+// Registry reports whether an instance of a chaincode is connected to the peer.
+type Registry interface {
+	Connected(cname string) bool
+}
+
+// Launcher launches chaincode.
+type Launcher interface {
+	Launch(ccci *ccprovider.ChaincodeContainerInfo) error
+}
+
+// Chaincodes remembers the container info of the chaincodes launched by the
+// peer, so they can be relaunched without a query executor. The zero value is
+// ready to use.
+type Chaincodes struct {
+	PollInterval      time.Duration
+	DisconnectTimeout time.Duration
+
+	mutex sync.Mutex
+	infos map[string]*ccprovider.ChaincodeContainerInfo
+}
+
+// Add records a launched chaincode.
+func (c *Chaincodes) Add(ccci *ccprovider.ChaincodeContainerInfo) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.infos == nil {
+		c.infos = map[string]*ccprovider.ChaincodeContainerInfo{}
+	}
+	c.infos[ccci.Name+":"+ccci.Version] = ccci
+}
+
+// Remove forgets a chaincode which was stopped or failed to launch.
+func (c *Chaincodes) Remove(cname string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.infos, cname)
+}
+
+func (c *Chaincodes) get(cname string) *ccprovider.ChaincodeContainerInfo {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.infos[cname]
+}
+
+// Relaunch launches a recorded chaincode again through launcher once its
+// running instance has disconnected from registry. A chaincode failing to
+// relaunch is forgotten.
+func (c *Chaincodes) Relaunch(cname string, registry Registry, launcher Launcher) error {
+	ccci := c.get(cname)
+	if ccci == nil {
+		return errors.Errorf("chaincode %s was not launched by this peer", cname)
+	}
+
+	pollInterval := c.PollInterval
+	if pollInterval == 0 {
+		pollInterval = DefaultPollInterval
+	}
+	disconnectTimeout := c.DisconnectTimeout
+	if disconnectTimeout == 0 {
+		disconnectTimeout = DefaultDisconnectTimeout
+	}
+
+	// The registry does not accept a second instance while the first is connected.
+	deadline := time.Now().Add(disconnectTimeout)
+	for registry.Connected(cname) {
+		if time.Now().After(deadline) {
+			return errors.Errorf("timeout expired while waiting for chaincode %s to disconnect", cname)
+		}
+		time.Sleep(pollInterval)
+	}
+
+	if err := launcher.Launch(ccci); err != nil {
+		c.Remove(cname)
+		return errors.WithMessage(err, "failed to relaunch chaincode "+cname)
+	}
+	return nil
+}
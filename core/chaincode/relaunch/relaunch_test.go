@@ -0,0 +1,78 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package relaunch
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/common/ccprovider"
+	"github.com/stretchr/testify/assert"
+)
+
+type registryFunc func(cname string) bool
+
+func (r registryFunc) Connected(cname string) bool { return r(cname) }
+
+type launcherFunc func(ccci *ccprovider.ChaincodeContainerInfo) error
+
+func (l launcherFunc) Launch(ccci *ccprovider.ChaincodeContainerInfo) error { return l(ccci) }
+
+func TestRelaunch(t *testing.T) {
+	connected := map[string]bool{}
+	registry := registryFunc(func(cname string) bool { return connected[cname] })
+
+	var launched []*ccprovider.ChaincodeContainerInfo
+	var launchErr error
+	launcher := launcherFunc(func(ccci *ccprovider.ChaincodeContainerInfo) error {
+		launched = append(launched, ccci)
+		return launchErr
+	})
+
+	c := &Chaincodes{PollInterval: time.Millisecond, DisconnectTimeout: 100 * time.Millisecond}
+
+	err := c.Relaunch("mycc:1.0", registry, launcher)
+	assert.EqualError(t, err, "chaincode mycc:1.0 was not launched by this peer")
+
+	ccci := &ccprovider.ChaincodeContainerInfo{Name: "mycc", Version: "1.0"}
+	c.Add(ccci)
+
+	// The running instance has to disconnect first.
+	connected["mycc:1.0"] = true
+	err = c.Relaunch("mycc:1.0", registry, launcher)
+	assert.EqualError(t, err, "timeout expired while waiting for chaincode mycc:1.0 to disconnect")
+	assert.Empty(t, launched)
+
+	delete(connected, "mycc:1.0")
+	assert.NoError(t, c.Relaunch("mycc:1.0", registry, launcher))
+	assert.Equal(t, []*ccprovider.ChaincodeContainerInfo{ccci}, launched)
+
+	launchErr = errors.New("boom")
+	err = c.Relaunch("mycc:1.0", registry, launcher)
+	assert.EqualError(t, err, "failed to relaunch chaincode mycc:1.0: boom")
+
+	// A chaincode failing to relaunch is forgotten.
+	err = c.Relaunch("mycc:1.0", registry, launcher)
+	assert.EqualError(t, err, "chaincode mycc:1.0 was not launched by this peer")
+	assert.Len(t, launched, 2)
+}
+
+func TestRemove(t *testing.T) {
+	c := &Chaincodes{}
+	c.Remove("mycc:1.0")
+
+	c.Add(&ccprovider.ChaincodeContainerInfo{Name: "mycc", Version: "1.0"})
+	c.Add(&ccprovider.ChaincodeContainerInfo{Name: "mycc", Version: "2.0"})
+	c.Remove("mycc:1.0")
+
+	launcher := launcherFunc(func(ccci *ccprovider.ChaincodeContainerInfo) error { return nil })
+	registry := registryFunc(func(string) bool { return false })
+	err := c.Relaunch("mycc:1.0", registry, launcher)
+	assert.EqualError(t, err, "chaincode mycc:1.0 was not launched by this peer")
+	assert.NoError(t, c.Relaunch("mycc:2.0", registry, launcher))
+}
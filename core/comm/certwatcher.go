@@ -0,0 +1,58 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package comm
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io/ioutil"
+	"time"
+)
+
+// WatchKeyPair polls the PEM encoded certificate and key files every interval
+// and passes the key pair to update whenever the content of either file
+// changes. Key pairs which fail to load, such as while only one of the files
+// has been replaced, are skipped until the files are consistent again, leaving
+// the previous key pair in use. WatchKeyPair returns when stop is closed.
+func WatchKeyPair(certFile, keyFile string, interval time.Duration, update func(tls.Certificate), stop <-chan struct{}) {
+	certPEM, _ := ioutil.ReadFile(certFile)
+	keyPEM, _ := ioutil.ReadFile(keyFile)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		newCertPEM, err := ioutil.ReadFile(certFile)
+		if err != nil {
+			commLogger.Warningf("Failed reading TLS certificate %s: %s", certFile, err)
+			continue
+		}
+		newKeyPEM, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			commLogger.Warningf("Failed reading TLS key %s: %s", keyFile, err)
+			continue
+		}
+		if bytes.Equal(newCertPEM, certPEM) && bytes.Equal(newKeyPEM, keyPEM) {
+			continue
+		}
+
+		cert, err := tls.X509KeyPair(newCertPEM, newKeyPEM)
+		if err != nil {
+			commLogger.Warningf("Ignoring updated TLS key pair %s, %s: %s", certFile, keyFile, err)
+			continue
+		}
+		commLogger.Infof("Reloaded TLS key pair from %s", certFile)
+		update(cert)
+		certPEM, keyPEM = newCertPEM, newKeyPEM
+	}
+}
@@ -0,0 +1,79 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package comm
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatchKeyPair(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "certwatcher")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	readPEM := func(host, name string) []byte {
+		pem, err := ioutil.ReadFile(filepath.Join("testdata", "dynamic_cert_update", host, name))
+		require.NoError(t, err)
+		return pem
+	}
+	certFile := filepath.Join(dir, "server.crt")
+	keyFile := filepath.Join(dir, "server.key")
+	require.NoError(t, ioutil.WriteFile(certFile, readPEM("notlocalhost", "server.crt"), 0600))
+	require.NoError(t, ioutil.WriteFile(keyFile, readPEM("notlocalhost", "server.key"), 0600))
+
+	updates := make(chan tls.Certificate, 10)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		WatchKeyPair(certFile, keyFile, 10*time.Millisecond, func(cert tls.Certificate) { updates <- cert }, stop)
+		close(done)
+	}()
+
+	// unchanged files are not reloaded
+	select {
+	case <-updates:
+		t.Fatal("unexpected reload of unchanged key pair")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// a certificate without its matching key is ignored
+	require.NoError(t, ioutil.WriteFile(certFile, readPEM("localhost", "server.crt"), 0600))
+	select {
+	case <-updates:
+		t.Fatal("unexpected reload of mismatched key pair")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// the new key pair is loaded once both files are replaced
+	require.NoError(t, ioutil.WriteFile(keyFile, readPEM("localhost", "server.key"), 0600))
+	expected, err := tls.X509KeyPair(readPEM("localhost", "server.crt"), readPEM("localhost", "server.key"))
+	require.NoError(t, err)
+	select {
+	case cert := <-updates:
+		assert.Equal(t, expected.Certificate, cert.Certificate)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for key pair reload")
+	}
+
+	close(stop)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("WatchKeyPair did not return after stop")
+	}
+	assert.Len(t, updates, 0)
+}
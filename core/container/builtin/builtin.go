@@ -0,0 +1,45 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package builtin registers the chaincode controllers shipped with fabric
+// which are not part of the docker controller. It is linked into the peer
+// with a blank import.
+package builtin
+
+import (
+	"github.com/hyperledger/fabric/core/container"
+	"github.com/hyperledger/fabric/core/container/nomadcontroller"
+	"github.com/hyperledger/fabric/core/container/processcontroller"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	container.RegisterController("nomad", newNomadProvider)
+	container.RegisterController("process", newProcessProvider)
+}
+
+// vmProvider returns the same VM for each chaincode.
+type vmProvider struct {
+	vm container.VM
+}
+
+func (v *vmProvider) NewVM() container.VM {
+	return v.vm
+}
+
+func newNomadProvider(config container.ControllerConfig) (container.VMProvider, error) {
+	api, err := nomadcontroller.NewNomadAPI(config.PeerID, config.NetworkID)
+	if err != nil {
+		return nil, errors.WithMessage(err, "nomad API unavailable")
+	}
+	return &vmProvider{vm: api}, nil
+}
+
+// newProcessProvider shares one ProcessVM between the chaincodes of a peer, as
+// the processes are tracked by the ProcessVM which started them.
+func newProcessProvider(config container.ControllerConfig) (container.VMProvider, error) {
+	return &vmProvider{vm: processcontroller.NewProcessVM(config.PeerID)}, nil
+}
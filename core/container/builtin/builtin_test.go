@@ -0,0 +1,39 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package builtin
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric/common/metrics/disabled"
+	"github.com/hyperledger/fabric/core/container"
+	"github.com/hyperledger/fabric/core/container/dockercontroller"
+	"github.com/hyperledger/fabric/core/container/nomadcontroller"
+	"github.com/hyperledger/fabric/core/container/processcontroller"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestControllers(t *testing.T) {
+	defer viper.Set("vm.controller", nil)
+	assert.Equal(t, []string{"docker", "kubernetes", "nomad", "podman", "process"}, container.Controllers())
+
+	p := dockercontroller.NewProvider("peer", "network", &disabled.Provider{})
+
+	viper.Set("vm.controller", "nomad")
+	_, ok := p.NewVM().(*nomadcontroller.NomadAPI)
+	assert.True(t, ok)
+
+	viper.Set("vm.controller", "process")
+	vm, ok := p.NewVM().(*processcontroller.ProcessVM)
+	assert.True(t, ok)
+	assert.Equal(t, "peer", vm.PeerID)
+	assert.Equal(t, vm, p.NewVM())
+
+	other := dockercontroller.NewProvider("peer", "network", &disabled.Provider{})
+	assert.True(t, vm != other.NewVM())
+}
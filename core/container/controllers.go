@@ -0,0 +1,74 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package container
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/hyperledger/fabric/common/metrics"
+)
+
+// ControllerConfig describes the peer a controller launches chaincode for.
+type ControllerConfig struct {
+	PeerID          string
+	NetworkID       string
+	MetricsProvider metrics.Provider
+	// Relaunch launches a chaincode previously launched by the peer again.
+	Relaunch func(ccName, ccVersion string) error
+}
+
+// ControllerFactory creates the VMProvider of a controller. It is called once
+// for each peer using the controller.
+type ControllerFactory func(config ControllerConfig) (VMProvider, error)
+
+var (
+	controllersMutex sync.RWMutex
+	controllers      = map[string]ControllerFactory{}
+)
+
+// RegisterController makes a controller selectable by setting vm.controller to
+// name. Controllers register from the init function of their package, which
+// is then linked into the peer with a blank import, see package builtin. It
+// panics if name is empty or already registered.
+func RegisterController(name string, factory ControllerFactory) {
+	controllersMutex.Lock()
+	defer controllersMutex.Unlock()
+
+	if name == "" {
+		panic("controller name must not be empty")
+	}
+	if factory == nil {
+		panic("controller factory for " + name + " is nil")
+	}
+	if _, ok := controllers[name]; ok {
+		panic("controller " + name + " is already registered")
+	}
+	controllers[name] = factory
+}
+
+// Controllers returns the sorted names of the registered controllers.
+func Controllers() []string {
+	controllersMutex.RLock()
+	defer controllersMutex.RUnlock()
+
+	names := make([]string, 0, len(controllers))
+	for name := range controllers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LookupController returns the factory of the controller registered as name.
+func LookupController(name string) (ControllerFactory, bool) {
+	controllersMutex.RLock()
+	defer controllersMutex.RUnlock()
+
+	factory, ok := controllers[name]
+	return factory, ok
+}
@@ -0,0 +1,76 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package container
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type vmProviderFunc func() VM
+
+func (f vmProviderFunc) NewVM() VM { return f() }
+
+func TestRegisterController(t *testing.T) {
+	defer func() {
+		controllersMutex.Lock()
+		delete(controllers, "custom")
+		delete(controllers, "broken")
+		controllersMutex.Unlock()
+	}()
+
+	custom := vmProviderFunc(func() VM { return nil })
+	RegisterController("custom", func(config ControllerConfig) (VMProvider, error) {
+		assert.Equal(t, "peer", config.PeerID)
+		return custom, nil
+	})
+	RegisterController("broken", func(config ControllerConfig) (VMProvider, error) {
+		return nil, errors.New("scheduler unreachable")
+	})
+	assert.Contains(t, Controllers(), "broken")
+	assert.Contains(t, Controllers(), "custom")
+
+	factory, ok := LookupController("custom")
+	assert.True(t, ok)
+	vmProvider, err := factory(ControllerConfig{PeerID: "peer"})
+	assert.NoError(t, err)
+	assert.NotNil(t, vmProvider)
+
+	factory, ok = LookupController("broken")
+	assert.True(t, ok)
+	_, err = factory(ControllerConfig{PeerID: "peer"})
+	assert.EqualError(t, err, "scheduler unreachable")
+
+	_, ok = LookupController("lxc")
+	assert.False(t, ok)
+
+	assert.PanicsWithValue(t, "controller custom is already registered", func() {
+		RegisterController("custom", func(config ControllerConfig) (VMProvider, error) { return nil, nil })
+	})
+	assert.PanicsWithValue(t, "controller name must not be empty", func() {
+		RegisterController("", func(config ControllerConfig) (VMProvider, error) { return nil, nil })
+	})
+	assert.PanicsWithValue(t, "controller factory for other is nil", func() {
+		RegisterController("other", nil)
+	})
+}
+
+func TestControllersSorted(t *testing.T) {
+	defer func() {
+		controllersMutex.Lock()
+		delete(controllers, "b")
+		delete(controllers, "a")
+		controllersMutex.Unlock()
+	}()
+
+	factory := func(config ControllerConfig) (VMProvider, error) { return nil, nil }
+	RegisterController("b", factory)
+	RegisterController("a", factory)
+	assert.Equal(t, []string{"a", "b"}, Controllers())
+}
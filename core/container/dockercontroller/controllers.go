@@ -0,0 +1,79 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dockercontroller
+
+import (
+	"sync"
+
+	"github.com/hyperledger/fabric/core/container"
+	"github.com/hyperledger/fabric/core/container/kubernetescontroller"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	container.RegisterController("docker", func(config container.ControllerConfig) (container.VMProvider, error) {
+		buildMetrics := NewBuildMetrics(config.MetricsProvider)
+		return vmProviderFunc(func() container.VM {
+			return NewDockerVM(config.PeerID, config.NetworkID, buildMetrics)
+		}), nil
+	})
+	container.RegisterController("kubernetes", func(config container.ControllerConfig) (container.VMProvider, error) {
+		return &kubernetesProvider{
+			config:            config,
+			buildMetrics:      NewBuildMetrics(config.MetricsProvider),
+			kubernetesMetrics: kubernetescontroller.NewBuildMetrics(config.MetricsProvider),
+			exitChannels:      kubernetescontroller.NewExitHandles(),
+		}, nil
+	})
+	container.RegisterController("podman", func(config container.ControllerConfig) (container.VMProvider, error) {
+		buildMetrics := NewBuildMetrics(config.MetricsProvider)
+		return vmProviderFunc(func() container.VM {
+			return NewPodmanVM(config.PeerID, config.NetworkID, buildMetrics)
+		}), nil
+	})
+}
+
+type vmProviderFunc func() container.VM
+
+func (f vmProviderFunc) NewVM() container.VM { return f() }
+
+// kubernetesProvider creates VMs launching chaincode as kubernetes pods.
+type kubernetesProvider struct {
+	config            container.ControllerConfig
+	buildMetrics      *BuildMetrics
+	kubernetesMetrics *kubernetescontroller.BuildMetrics
+	exitChannels      *kubernetescontroller.ExitHandles
+	preemption        sync.Once
+}
+
+func (k *kubernetesProvider) NewVM() container.VM {
+	var vm container.VM
+	api, err := kubernetescontroller.NewKubernetesAPI(k.config.PeerID, k.config.NetworkID, k.exitChannels)
+	if err != nil {
+		// Surface the failure when the chaincode is launched rather than bringing down the peer.
+		dockerLogger.Errorf("Kubernetes API unavailable: %s", err)
+		vm = &unavailableVM{err: errors.WithMessage(err, "kubernetes API unavailable")}
+	} else {
+		api.BuildMetrics = k.kubernetesMetrics
+		vm = api
+		if viper.GetBool("vm.kubernetes.spot.watchPreemption") {
+			k.preemption.Do(func() { go api.WatchPreemption(nil, k.config.Relaunch) })
+		}
+	}
+
+	// Chaincodes matching vm.kubernetes.dockerChaincodes continue to use docker.
+	patterns := dockerChaincodePatterns()
+	if len(patterns) == 0 {
+		return vm
+	}
+	return &routingVM{
+		kubernetes: vm,
+		docker:     NewDockerVM(k.config.PeerID, k.config.NetworkID, k.buildMetrics),
+		patterns:   patterns,
+	}
+}
@@ -0,0 +1,59 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dockercontroller
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/metrics/disabled"
+	"github.com/hyperledger/fabric/core/container"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProviderControllers(t *testing.T) {
+	defer viper.Set("vm.controller", nil)
+	assert.Equal(t, []string{"docker", "kubernetes", "podman"}, container.Controllers())
+
+	p := NewProvider("peer", "network", &disabled.Provider{})
+
+	viper.Set("vm.controller", "docker")
+	_, ok := p.NewVM().(*DockerVM)
+	assert.True(t, ok)
+	dockerProvider := p.vmProviders["docker"]
+	assert.NotNil(t, dockerProvider)
+
+	// The controller is created once per provider.
+	p.NewVM()
+	assert.Len(t, p.vmProviders, 1)
+
+	viper.Set("vm.controller", "kubernetes")
+	vm := p.NewVM()
+	assert.NotNil(t, vm)
+	kubernetesProvider, ok := p.vmProviders["kubernetes"].(*kubernetesProvider)
+	assert.True(t, ok)
+	assert.Equal(t, "peer", kubernetesProvider.config.PeerID)
+	assert.Equal(t, "network", kubernetesProvider.config.NetworkID)
+	assert.NotNil(t, kubernetesProvider.config.Relaunch)
+	assert.Len(t, p.vmProviders, 2)
+
+	viper.Set("vm.controller", "lxc")
+	_, err := p.NewControllerVM()
+	assert.EqualError(t, err, "unsupported vm.controller 'lxc', must be one of docker, kubernetes, podman")
+	assert.Len(t, p.vmProviders, 2)
+}
+
+func TestProviderRelaunch(t *testing.T) {
+	p := NewProvider("peer", "network", &disabled.Provider{})
+	assert.EqualError(t, p.relaunch("mycc", "1.0"), "chaincode relaunch is not configured")
+
+	p.Relaunch = func(ccName, ccVersion string) error {
+		return errors.New("relaunch " + ccName + ":" + ccVersion)
+	}
+	assert.EqualError(t, p.relaunch("mycc", "1.0"), "relaunch mycc:1.0")
+}
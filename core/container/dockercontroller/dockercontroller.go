@@ -18,6 +18,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	docker "github.com/fsouza/go-dockerclient"
@@ -92,33 +93,123 @@ type dockerClient interface {
 
 // Provider implements container.VMProvider
 type Provider struct {
-	PeerID       string
-	NetworkID    string
-	BuildMetrics *BuildMetrics
-
-	exitChannels *kubernetescontroller.ExitHandles
+	PeerID    string
+	NetworkID string
+	// Relaunch launches chaincode removed from preempted kubernetes nodes again.
+	Relaunch kubernetescontroller.Relauncher
+
+	metricsProvider metrics.Provider
+	mutex           sync.Mutex
+	vmProviders     map[string]container.VMProvider
 }
 
 // NewProvider creates a new instance of Provider
 func NewProvider(peerID, networkID string, metricsProvider metrics.Provider) *Provider {
 	return &Provider{
-		PeerID:       peerID,
-		NetworkID:    networkID,
-		BuildMetrics: NewBuildMetrics(metricsProvider),
-		exitChannels: kubernetescontroller.NewExitHandles(),
+		PeerID:          peerID,
+		NetworkID:       networkID,
+		metricsProvider: metricsProvider,
+		vmProviders:     map[string]container.VMProvider{},
 	}
 }
 
-// NewVM creates a new DockerVM instance
+// NewVM creates a new VM instance for the controller selected by
+// vm.controller, see container.RegisterController. A VM which cannot be
+// created reports the failure when chaincode is launched.
 func (p *Provider) NewVM() container.VM {
+	vm, err := p.NewControllerVM()
+	if err != nil {
+		// Surface the failure when the chaincode is launched rather than bringing down the peer.
+		dockerLogger.Errorf("Controller %s unavailable: %s", p.Controller(), err)
+		return &unavailableVM{err: err}
+	}
+	return vm
+}
+
+// NewControllerVM creates a new VM instance for the controller selected by
+// vm.controller, returning an error if the controller is not registered or
+// cannot be created. The peer calls it at startup to reject a bad
+// configuration.
+func (p *Provider) NewControllerVM() (container.VM, error) {
+	vmProvider, err := p.vmProvider(p.Controller())
+	if err != nil {
+		return nil, err
+	}
+	return vmProvider.NewVM(), nil
+}
+
+// vmProvider returns the VMProvider of the named controller, creating it on
+// first use. A controller which fails to be created is retried on next use.
+func (p *Provider) vmProvider(controller string) (container.VMProvider, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if vmProvider, ok := p.vmProviders[controller]; ok {
+		return vmProvider, nil
+	}
+	factory, ok := container.LookupController(controller)
+	if !ok {
+		return nil, errors.Errorf("unsupported vm.controller '%s', must be one of %s", controller, strings.Join(container.Controllers(), ", "))
+	}
+	vmProvider, err := factory(container.ControllerConfig{
+		PeerID:          p.PeerID,
+		NetworkID:       p.NetworkID,
+		MetricsProvider: p.metricsProvider,
+		Relaunch:        p.relaunch,
+	})
+	if err != nil {
+		return nil, err
+	}
+	p.vmProviders[controller] = vmProvider
+	return vmProvider, nil
+}
+
+// relaunch defers to Relaunch, which the peer sets once chaincode support
+// has been created.
+func (p *Provider) relaunch(ccName, ccVersion string) error {
+	if p.Relaunch == nil {
+		return errors.New("chaincode relaunch is not configured")
+	}
+	return p.Relaunch(ccName, ccVersion)
+}
+
+// Controller returns the name of the controller selected by vm.controller.
+// When no controller is selected, the kubernetes API is used inside a
+// kubernetes cluster and docker otherwise.
+func (p *Provider) Controller() string {
+	if controller := viper.GetString("vm.controller"); controller != "" {
+		return controller
+	}
 	// At this point check to see if we are in kubernetes
 	if !kubernetescontroller.InCluster() {
 		dockerLogger.Info("Kubernetes not detected.")
-		return NewDockerVM(p.PeerID, p.NetworkID, p.BuildMetrics)
+		return "docker"
 	}
 	// In a cluster so replace the docker connection with a kubernetes one.
 	dockerLogger.Info("Kubernetes environment detected. Using K8s API.")
-	return kubernetescontroller.NewKubernetesAPI(p.PeerID, p.NetworkID, p.exitChannels)
+	return "kubernetes"
+}
+
+// unavailableVM is returned in place of a VM which could not be created and
+// reports the creation failure from each of its operations.
+type unavailableVM struct {
+	err error
+}
+
+func (u *unavailableVM) Start(ccid ccintf.CCID, args, env []string, filesToUpload map[string][]byte, builder container.Builder) error {
+	return u.err
+}
+
+func (u *unavailableVM) Stop(ccid ccintf.CCID, timeout uint, dontkill bool, dontremove bool) error {
+	return u.err
+}
+
+func (u *unavailableVM) Wait(ccid ccintf.CCID) (int, error) {
+	return 0, u.err
+}
+
+func (u *unavailableVM) HealthCheck(ctx context.Context) error {
+	return u.err
 }
 
 // NewDockerVM returns a new DockerVM instance
@@ -0,0 +1,46 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dockercontroller
+
+import (
+	"os"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/spf13/viper"
+)
+
+// defaultPodmanEndpoint is the socket of the rootful podman service, used when
+// vm.podman.endpoint is not set and no user runtime directory is available.
+const defaultPodmanEndpoint = "unix:///run/podman/podman.sock"
+
+// NewPodmanVM returns a DockerVM which launches chaincode through the Docker
+// compatible REST API of the podman service, allowing chaincode to run rootless
+// on hosts without a Docker daemon.
+func NewPodmanVM(peerID, networkID string, buildMetrics *BuildMetrics) *DockerVM {
+	return &DockerVM{
+		PeerID:       peerID,
+		NetworkID:    networkID,
+		getClientFnc: getPodmanClient,
+		BuildMetrics: buildMetrics,
+	}
+}
+
+func getPodmanClient() (dockerClient, error) {
+	return docker.NewClient(podmanEndpoint())
+}
+
+// podmanEndpoint returns vm.podman.endpoint or, when not set, the socket of the
+// rootless podman service of the user running the peer.
+func podmanEndpoint() string {
+	if endpoint := viper.GetString("vm.podman.endpoint"); endpoint != "" {
+		return endpoint
+	}
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		return "unix://" + runtimeDir + "/podman/podman.sock"
+	}
+	return defaultPodmanEndpoint
+}
@@ -0,0 +1,53 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dockercontroller
+
+import (
+	"os"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/metrics/disabled"
+	"github.com/hyperledger/fabric/core/container/ccintf"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPodmanEndpoint(t *testing.T) {
+	defer viper.Set("vm.podman.endpoint", nil)
+	defer os.Setenv("XDG_RUNTIME_DIR", os.Getenv("XDG_RUNTIME_DIR"))
+
+	viper.Set("vm.podman.endpoint", "tcp://localhost:8080")
+	assert.Equal(t, "tcp://localhost:8080", podmanEndpoint())
+
+	viper.Set("vm.podman.endpoint", nil)
+	os.Setenv("XDG_RUNTIME_DIR", "/run/user/1000")
+	assert.Equal(t, "unix:///run/user/1000/podman/podman.sock", podmanEndpoint())
+
+	os.Unsetenv("XDG_RUNTIME_DIR")
+	assert.Equal(t, defaultPodmanEndpoint, podmanEndpoint())
+}
+
+func TestProviderNewVMController(t *testing.T) {
+	defer viper.Set("vm.controller", nil)
+	p := NewProvider("peer", "network", &disabled.Provider{})
+
+	viper.Set("vm.controller", "docker")
+	vm, ok := p.NewVM().(*DockerVM)
+	assert.True(t, ok)
+	assert.NotNil(t, vm.getClientFnc)
+
+	viper.Set("vm.controller", "podman")
+	vm, ok = p.NewVM().(*DockerVM)
+	assert.True(t, ok)
+	assert.Equal(t, "peer", vm.PeerID)
+
+	viper.Set("vm.controller", "lxc")
+	err := p.NewVM().Start(ccintf.CCID{Name: "mycc"}, nil, nil, nil, nil)
+	assert.EqualError(t, err, "unsupported vm.controller 'lxc', must be one of docker, kubernetes, podman")
+	_, err = p.NewControllerVM()
+	assert.EqualError(t, err, "unsupported vm.controller 'lxc', must be one of docker, kubernetes, podman")
+}
@@ -0,0 +1,72 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dockercontroller
+
+import (
+	"context"
+	"path"
+
+	"github.com/hyperledger/fabric/core/container"
+	"github.com/hyperledger/fabric/core/container/ccintf"
+	"github.com/spf13/viper"
+)
+
+// routingVM dispatches chaincode operations to the docker VM for chaincodes
+// whose names match one of the configured patterns, and to the kubernetes VM
+// for all others.
+type routingVM struct {
+	kubernetes container.VM
+	docker     container.VM
+	patterns   []string
+}
+
+// dockerChaincodePatterns returns the valid chaincode name patterns from
+// vm.kubernetes.dockerChaincodes which should be launched through docker
+// while running inside a kubernetes cluster.
+func dockerChaincodePatterns() []string {
+	var patterns []string
+	for _, p := range viper.GetStringSlice("vm.kubernetes.dockerChaincodes") {
+		if _, err := path.Match(p, ""); err != nil {
+			dockerLogger.Warningf("Ignoring invalid docker chaincode pattern '%s': %s", p, err)
+			continue
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns
+}
+
+// vmFor returns the VM responsible for the given chaincode.
+func (r *routingVM) vmFor(ccid ccintf.CCID) container.VM {
+	for _, p := range r.patterns {
+		if matched, _ := path.Match(p, ccid.Name); matched {
+			dockerLogger.Debugf("Chaincode %s matches docker pattern '%s'", ccid.Name, p)
+			return r.docker
+		}
+	}
+	return r.kubernetes
+}
+
+// Start starts the chaincode using the VM selected for it
+func (r *routingVM) Start(ccid ccintf.CCID, args, env []string, filesToUpload map[string][]byte, builder container.Builder) error {
+	return r.vmFor(ccid).Start(ccid, args, env, filesToUpload, builder)
+}
+
+// Stop stops the chaincode using the VM selected for it
+func (r *routingVM) Stop(ccid ccintf.CCID, timeout uint, dontkill bool, dontremove bool) error {
+	return r.vmFor(ccid).Stop(ccid, timeout, dontkill, dontremove)
+}
+
+// Wait waits for the chaincode to exit using the VM selected for it
+func (r *routingVM) Wait(ccid ccintf.CCID) (int, error) {
+	return r.vmFor(ccid).Wait(ccid)
+}
+
+// HealthCheck checks the health of the kubernetes VM; the docker VM is
+// registered with the operations system separately.
+func (r *routingVM) HealthCheck(ctx context.Context) error {
+	return r.kubernetes.HealthCheck(ctx)
+}
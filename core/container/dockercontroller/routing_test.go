@@ -0,0 +1,58 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dockercontroller
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric/core/container/ccintf"
+	cmock "github.com/hyperledger/fabric/core/container/mock"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoutingVM(t *testing.T) {
+	kubeVM := &cmock.VM{}
+	dockerVM := &cmock.VM{}
+	r := &routingVM{
+		kubernetes: kubeVM,
+		docker:     dockerVM,
+		patterns:   []string{"dev-*", "mycc"},
+	}
+
+	for _, name := range []string{"dev-cc", "mycc"} {
+		err := r.Start(ccintf.CCID{Name: name}, nil, nil, nil, nil)
+		assert.NoError(t, err)
+	}
+	r.Stop(ccintf.CCID{Name: "dev-cc"}, 0, false, false)
+	r.Wait(ccintf.CCID{Name: "dev-cc"})
+	assert.Equal(t, 2, dockerVM.StartCallCount())
+	assert.Equal(t, 1, dockerVM.StopCallCount())
+	assert.Equal(t, 1, dockerVM.WaitCallCount())
+
+	err := r.Start(ccintf.CCID{Name: "mycc2"}, nil, nil, nil, nil)
+	assert.NoError(t, err)
+	r.Stop(ccintf.CCID{Name: "prod-cc"}, 0, false, false)
+	r.Wait(ccintf.CCID{Name: "prod-cc"})
+	assert.Equal(t, 1, kubeVM.StartCallCount())
+	assert.Equal(t, 1, kubeVM.StopCallCount())
+	assert.Equal(t, 1, kubeVM.WaitCallCount())
+
+	r.HealthCheck(nil)
+	assert.Equal(t, 1, kubeVM.HealthCheckCallCount())
+	assert.Equal(t, 0, dockerVM.HealthCheckCallCount())
+}
+
+func TestDockerChaincodePatterns(t *testing.T) {
+	defer viper.Set("vm.kubernetes.dockerChaincodes", nil)
+
+	viper.Set("vm.kubernetes.dockerChaincodes", []string{"dev-*", "[bad", "mycc"})
+	assert.Equal(t, []string{"dev-*", "mycc"}, dockerChaincodePatterns())
+
+	viper.Set("vm.kubernetes.dockerChaincodes", nil)
+	assert.Empty(t, dockerChaincodePatterns())
+}
@@ -16,9 +16,13 @@ import (
 	"regexp"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/hyperledger/fabric/core/chaincode"
+	"github.com/pkg/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/watch"
 
 	"github.com/spf13/viper"
 
@@ -36,13 +40,42 @@ import (
 // is registered with the container.VMController
 const ContainerType = "KUBERNETES"
 
+// defaultStartTimeout is the time Start waits for the chaincode pod to become ready when
+// vm.kubernetes.startTimeout is not set.
+const defaultStartTimeout = 5 * time.Minute
+
+// defaultClientBackoff is the time a failure to create the kubernetes client is cached when
+// vm.kubernetes.client.failureBackoff is not set.
+const defaultClientBackoff = 30 * time.Second
+
+// defaultMaxRestarts is the number of container restarts tolerated when vm.kubernetes.maxRestarts is not set.
+const defaultMaxRestarts = 5
+
 var (
 	kubernetesLogger = flogging.MustGetLogger("kubernetescontroller")
 	clusterConfig    *rest.Config
 	podRegExp        = regexp.MustCompile("[^a-zA-Z0-9-_.]")
 )
 
-type getClient func() (*kubernetes.Clientset, error)
+type getClient func() (kubernetes.Interface, error)
+
+// newClient builds the clientset used by the API, replaceable for testing
+var newClient getClient = getKubernetesClient
+
+// sharedClient holds the client shared by the KubernetesAPI instances created for each chaincode request.  A failure
+// to create it is cached for a backoff so requests made while the API server is unavailable fail fast instead of
+// each waiting out the retries.
+var sharedClient struct {
+	sync.Mutex
+	client     kubernetes.Interface
+	err        error
+	retryAfter time.Time
+}
+
+// configError is returned by newClient when the client configuration is invalid, which retrying cannot fix.
+type configError struct {
+	error
+}
 
 // ExitHandles structure holds a conncurrent hashmap instance of references to channels
 type ExitHandles struct {
@@ -71,6 +104,32 @@ func (handles *ExitHandles) RemoveInstance(name string) {
 	delete(handles.chaincodes, name)
 }
 
+// CloseInstance delivers the exit reason (if not empty) to the channel associated with the given chaincode name,
+// closes it and removes it from the registry.  Returns false if there was no channel registered.
+func (handles *ExitHandles) CloseInstance(name string, reason string) bool {
+	return handles.closeInstance(name, nil, reason)
+}
+
+// closeInstance closes the channel associated with the given chaincode name, only if it is the expected channel
+// when one is given.
+func (handles *ExitHandles) closeInstance(name string, expected *chan string, reason string) bool {
+	handles.mutex.Lock()
+	defer handles.mutex.Unlock()
+	inst, ok := handles.chaincodes[name]
+	if !ok || (expected != nil && inst != expected) {
+		return false
+	}
+	if reason != "" {
+		select {
+		case *inst <- reason:
+		default:
+		}
+	}
+	close(*inst)
+	delete(handles.chaincodes, name)
+	return true
+}
+
 // NewExitHandles creates a new ExitHandles registry instance
 func NewExitHandles() *ExitHandles {
 	return &ExitHandles{
@@ -80,7 +139,7 @@ func NewExitHandles() *ExitHandles {
 
 // KubernetesAPI instance for a peer to schedule chaincodes.
 type KubernetesAPI struct {
-	client *kubernetes.Clientset
+	client kubernetes.Interface
 
 	PeerID       string
 	Namespace    string
@@ -89,8 +148,10 @@ type KubernetesAPI struct {
 	chaincodes *ExitHandles
 }
 
-// NewKubernetesAPI creates an instance using the environmental Kubernetes configuration
-func NewKubernetesAPI(peerID, networkID string, exitHandles *ExitHandles) *KubernetesAPI {
+// NewKubernetesAPI creates an instance using the environmental Kubernetes configuration.  Creation of the
+// client is retried (vm.kubernetes.client.retries, vm.kubernetes.client.retryInterval) before giving up, and the
+// failure is returned without retrying for vm.kubernetes.client.failureBackoff.
+func NewKubernetesAPI(peerID, networkID string, exitHandles *ExitHandles) (*KubernetesAPI, error) {
 	// Empty or host networks map to default kubernetes namespace.
 	namespace := viper.GetString("vm.kubernetes.namespace")
 	if len(namespace) == 0 {
@@ -103,16 +164,95 @@ func NewKubernetesAPI(peerID, networkID string, exitHandles *ExitHandles) *Kuber
 		Namespace: namespace,
 	}
 
-	client, err := getKubernetesClient()
+	client, err := getSharedClient()
 	if err != nil {
-		kubernetesLogger.Debugf("NewKubernetesAPI - cannot create kubernetes client %s", err)
-		panic(err)
+		kubernetesLogger.Errorf("NewKubernetesAPI - cannot create kubernetes client %s", err)
+		return nil, err
 	}
 
 	api.client = client
 	api.chaincodes = exitHandles
 
-	return &api
+	return &api, nil
+}
+
+// NewKubernetesAPIForClient creates an instance scheduling chaincode in the namespace through the given client.
+func NewKubernetesAPIForClient(client kubernetes.Interface, peerID, namespace string, exitHandles *ExitHandles) *KubernetesAPI {
+	return &KubernetesAPI{
+		client:     client,
+		PeerID:     peerID,
+		Namespace:  namespace,
+		chaincodes: exitHandles,
+	}
+}
+
+// connectWithRetry attempts to create a kubernetes client, retrying on failure to ride out transient API issues.
+func connectWithRetry() (kubernetes.Interface, error) {
+	retries := 3
+	if viper.IsSet("vm.kubernetes.client.retries") {
+		retries = viper.GetInt("vm.kubernetes.client.retries")
+	}
+	if retries < 0 {
+		retries = 0
+	}
+	interval := viper.GetDuration("vm.kubernetes.client.retryInterval")
+	if interval <= 0 {
+		interval = 2 * time.Second
+	}
+
+	var err error
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			kubernetesLogger.Warningf("Unable to create kubernetes client (attempt %d of %d): %s", attempt, retries+1, err)
+			time.Sleep(interval)
+		}
+
+		var client kubernetes.Interface
+		client, err = newClient()
+		if err == nil {
+			return client, nil
+		}
+		if _, ok := err.(configError); ok {
+			return nil, errors.WithMessage(err, "failed to create kubernetes client")
+		}
+	}
+	return nil, errors.Wrapf(err, "failed to create kubernetes client after %d attempts", retries+1)
+}
+
+// getSharedClient returns the shared client, connecting when it has not been created yet and no earlier failure is
+// cached.  The lock is not held while connecting, so callers are not blocked for the whole retry window.
+func getSharedClient() (kubernetes.Interface, error) {
+	sharedClient.Lock()
+	if sharedClient.client != nil {
+		defer sharedClient.Unlock()
+		return sharedClient.client, nil
+	}
+	if sharedClient.err != nil && time.Now().Before(sharedClient.retryAfter) {
+		defer sharedClient.Unlock()
+		return nil, sharedClient.err
+	}
+	sharedClient.Unlock()
+
+	client, err := connectWithRetry()
+
+	sharedClient.Lock()
+	defer sharedClient.Unlock()
+	if sharedClient.client != nil {
+		// Another caller connected in the meantime.
+		return sharedClient.client, nil
+	}
+	if err != nil {
+		backoff := viper.GetDuration("vm.kubernetes.client.failureBackoff")
+		if backoff <= 0 {
+			backoff = defaultClientBackoff
+		}
+		sharedClient.err = err
+		sharedClient.retryAfter = time.Now().Add(backoff)
+		return nil, err
+	}
+
+	sharedClient.client, sharedClient.err = client, nil
+	return client, nil
 }
 
 // InCluster returns true if the process is running in a pod inside a kubernetes cluster (and configuration can be accessed)
@@ -145,14 +285,18 @@ func InCluster() bool {
 	return true
 }
 
-func getKubernetesClient() (*kubernetes.Clientset, error) {
+func getKubernetesClient() (kubernetes.Interface, error) {
 	// creates the in-cluster config
 	config, err := rest.InClusterConfig()
 	if err != nil {
-		panic(err.Error())
+		return nil, configError{err}
 	}
 	// creates the clientset
-	return kubernetes.NewForConfig(config)
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, configError{err}
+	}
+	return client, nil
 }
 
 // Start a pod in kubernetes for the chaincode
@@ -161,13 +305,19 @@ func (api *KubernetesAPI) Start(ccid ccintf.CCID,
 
 	kubernetesLogger.Infof("Starting chaincode %s...", api.GetPodName(ccid))
 
+	// The resource quota may still count the pods being replaced when the new pod is checked against it.
+	var replaced []apiv1.Pod
+	if pods, err := api.FindPeerCCPods(ccid); err == nil {
+		replaced = pods.Items
+	}
+
 	// Clean up any existing deployments (why do this?)
 	api.stopAllInternal(ccid)
 
 	// Inject the peer and version information.
 	env = append(env, chaincode.E2eeConfigs(api.PeerID+"."+api.Namespace, ccid.Name, ccid.Version)...)
 
-	deploy, err := api.createChaincodePodDeployment(ccid, args, env, filesToUpload)
+	deploy, err := api.createChaincodePodDeployment(ccid, args, env, filesToUpload, replaced)
 	if err != nil {
 		kubernetesLogger.Errorf("start - cannot create chaincode deploy %s", err)
 		return err
@@ -177,10 +327,124 @@ func (api *KubernetesAPI) Start(ccid ccintf.CCID,
 	ccchan := make(chan string, 1)
 	api.chaincodes.SetInstance(api.GetPodName(ccid), &ccchan)
 
+	maxRestarts := getMaxRestarts()
+	if err := api.waitForPodReady(deploy, getStartTimeout(), maxRestarts); err != nil {
+		kubernetesLogger.Errorf("start - chaincode pod %s did not become ready: %s", deploy.GetName(), err)
+		// Remove the pod, its files and exit handle rather than leaving the failed chaincode behind.
+		api.chaincodes.CloseInstance(deploy.GetName(), "")
+		if stopErr := api.stopAllInternal(ccid); stopErr != nil {
+			kubernetesLogger.Warningf("start - failed to remove chaincode pod %s: %s", deploy.GetName(), stopErr)
+		}
+		return err
+	}
+
+	// Watch for the pod exiting, being deleted or evicted, or crash looping so Wait returns.
+	go api.monitorPod(deploy, maxRestarts)
+
 	kubernetesLogger.Infof("Chaincode %s started successfully.", deploy.GetName())
 	return nil
 }
 
+// getStartTimeout returns the configured vm.kubernetes.startTimeout or the default when not set.
+func getStartTimeout() time.Duration {
+	timeout := viper.GetDuration("vm.kubernetes.startTimeout")
+	if timeout <= 0 {
+		return defaultStartTimeout
+	}
+	return timeout
+}
+
+// getMaxRestarts returns the configured vm.kubernetes.maxRestarts or the default when not set.
+func getMaxRestarts() int {
+	if !viper.IsSet("vm.kubernetes.maxRestarts") {
+		return defaultMaxRestarts
+	}
+	return viper.GetInt("vm.kubernetes.maxRestarts")
+}
+
+// getRestartPolicy returns the pod restart policy configured by vm.kubernetes.restartPolicy, defaulting to Never.
+func getRestartPolicy() (apiv1.RestartPolicy, error) {
+	policy := apiv1.RestartPolicy(viper.GetString("vm.kubernetes.restartPolicy"))
+	switch policy {
+	case "":
+		return apiv1.RestartPolicyNever, nil
+	case apiv1.RestartPolicyNever, apiv1.RestartPolicyOnFailure, apiv1.RestartPolicyAlways:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("invalid vm.kubernetes.restartPolicy '%s', must be one of Never, OnFailure or Always", policy)
+	}
+}
+
+// waitForPodReady watches the pod until it is running and ready, returning an error if the pod fails, is
+// deleted, restarts more than maxRestarts times, or does not become ready within the timeout.
+func (api *KubernetesAPI) waitForPodReady(pod *apiv1.Pod, timeout time.Duration, maxRestarts int) error {
+	if ready, err := podReady(pod, maxRestarts); ready || err != nil {
+		return err
+	}
+
+	w, err := api.client.CoreV1().Pods(api.Namespace).Watch(metav1.ListOptions{
+		FieldSelector:   fields.OneTermEqualSelector("metadata.name", pod.Name).String(),
+		ResourceVersion: pod.ResourceVersion,
+	})
+	if err != nil {
+		return err
+	}
+	defer w.Stop()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case event, ok := <-w.ResultChan():
+			if !ok {
+				return fmt.Errorf("watch of pod %s closed before it became ready", pod.Name)
+			}
+			p, ok := event.Object.(*apiv1.Pod)
+			if !ok || p.Name != pod.Name {
+				continue
+			}
+			if event.Type == watch.Deleted {
+				return fmt.Errorf("pod %s was deleted before it became ready", pod.Name)
+			}
+			if ready, err := podReady(p, maxRestarts); ready || err != nil {
+				return err
+			}
+		case <-timer.C:
+			return fmt.Errorf("timed out after %s waiting for pod %s to become ready", timeout, pod.Name)
+		}
+	}
+}
+
+// podReady returns true when the pod is running and ready, or an error if it can no longer become ready.
+func podReady(pod *apiv1.Pod, maxRestarts int) (bool, error) {
+	if err := checkRestarts(pod, maxRestarts); err != nil {
+		return false, err
+	}
+
+	switch pod.Status.Phase {
+	case apiv1.PodFailed, apiv1.PodSucceeded:
+		return false, fmt.Errorf("pod %s exited with phase %s: %s", pod.Name, pod.Status.Phase, pod.Status.Message)
+	case apiv1.PodRunning:
+		for _, c := range pod.Status.Conditions {
+			if c.Type == apiv1.PodReady && c.Status == apiv1.ConditionTrue {
+				return true, nil
+			}
+		}
+	}
+
+	for _, cs := range pod.Status.ContainerStatuses {
+		if cs.State.Waiting == nil {
+			continue
+		}
+		switch cs.State.Waiting.Reason {
+		case "ErrImagePull", "ImagePullBackOff", "InvalidImageName":
+			return false, fmt.Errorf("pod %s cannot pull image %s: %s", pod.Name, cs.Image, cs.State.Waiting.Message)
+		}
+	}
+	return false, nil
+}
+
 // Stop a running pod in kubernetes
 func (api *KubernetesAPI) Stop(ccid ccintf.CCID, timeout uint, dontkill bool, dontremove bool) error {
 	kubernetesLogger.Infof("Stop chaincode %s requested. [kill=%t, remove=%t]", ccid.Name, !dontkill, !dontremove)
@@ -199,7 +463,11 @@ func (api *KubernetesAPI) Wait(ccid ccintf.CCID) (int, error) {
 		return 0, fmt.Errorf("%s not found", podName)
 	}
 
-	<-*cc // wait in the chaincode stop channel to return something (or close)
+	// wait in the chaincode stop channel to return something (or close)
+	if reason := <-*cc; reason != "" {
+		kubernetesLogger.Warningf("Chaincode %s exited: %s", podName, reason)
+		return 0, fmt.Errorf("chaincode %s exited: %s", podName, reason)
+	}
 
 	kubernetesLogger.Infof("Chaincode %s exited.", podName)
 
@@ -212,15 +480,36 @@ func (api *KubernetesAPI) HealthCheck(ctx context.Context) error {
 	return nil
 }
 
-func (api *KubernetesAPI) createChaincodePodDeployment(ccid ccintf.CCID, args []string, env []string, filesToUpload map[string][]byte) (*apiv1.Pod, error) {
+func (api *KubernetesAPI) createChaincodePodDeployment(ccid ccintf.CCID, args []string, env []string, filesToUpload map[string][]byte, replaced []apiv1.Pod) (*apiv1.Pod, error) {
 	podName := api.GetPodName(ccid)
 	kubernetesLogger.Info("Starting chaincode", podName)
 
-	mountPoint, configMap, err := api.createChainCodeFilesConfigMap(podName, filesToUpload)
+	// Read in resource limits and requests from config.
+	resourceRequest, err := getResourceRequest()
+	if err != nil {
+		return nil, err
+	}
+
+	restartPolicy, err := getRestartPolicy()
+	if err != nil {
+		return nil, err
+	}
+
+	nodeAffinity, err := getSpotNodeAffinity()
+	if err != nil {
+		return nil, err
+	}
+	nodeAffinity = excludePreemptingNodes(nodeAffinity)
+
+	mountPoint, volumes, initContainers, err := api.createChainCodeFilesVolumes(ccid, podName, filesToUpload)
 	if err != nil {
 		kubernetesLogger.Errorf("Could not create config map for peer chaincode pod. %s", err)
 		return nil, err
 	}
+	// Quotas on limits or requests reject pods with containers which do not specify them.
+	for i := range initContainers {
+		initContainers[i].Resources = resourceRequest
+	}
 
 	envvars := []apiv1.EnvVar{}
 	for _, v := range env {
@@ -233,12 +522,6 @@ func (api *KubernetesAPI) createChaincodePodDeployment(ccid ccintf.CCID, args []
 	weight := int32(50)
 	labelExp, err := metav1.ParseToLabelSelector(fmt.Sprintf("Name == %s", api.PeerID))
 
-	// Read in resource limits and requests from config.
-	resourceRequest, err := getResourceRequest()
-	if err != nil {
-		return nil, err
-	}
-
 	pod := &apiv1.Pod{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: podName,
@@ -251,7 +534,8 @@ func (api *KubernetesAPI) createChaincodePodDeployment(ccid ccintf.CCID, args []
 			},
 		},
 		Spec: apiv1.PodSpec{
-			RestartPolicy: "Never", // If we exit for any reason rely on the Peer to reschedule.
+			RestartPolicy:  restartPolicy, // Defaults to Never, if we exit for any reason rely on the Peer to reschedule.
+			InitContainers: initContainers,
 			Containers: []apiv1.Container{
 				{
 					Name:  "fabric-chaincode-" + ccid.Name,
@@ -268,6 +552,7 @@ func (api *KubernetesAPI) createChaincodePodDeployment(ccid ccintf.CCID, args []
 				},
 			},
 			Affinity: &apiv1.Affinity{
+				NodeAffinity: nodeAffinity,
 				PodAffinity: &apiv1.PodAffinity{
 					PreferredDuringSchedulingIgnoredDuringExecution: []apiv1.WeightedPodAffinityTerm{
 						{
@@ -280,20 +565,19 @@ func (api *KubernetesAPI) createChaincodePodDeployment(ccid ccintf.CCID, args []
 					},
 				},
 			},
-			Volumes: []apiv1.Volume{
-				{
-					Name: "uploadedfiles-volume",
-					VolumeSource: apiv1.VolumeSource{
-						ConfigMap: &apiv1.ConfigMapVolumeSource{
-							LocalObjectReference: apiv1.LocalObjectReference{
-								Name: configMap.Name,
-							},
-						},
-					},
-				},
-			},
+			Volumes: volumes,
 		},
 	}
+
+	// Fail early with an actionable error rather than a late Forbidden from the pod create.
+	if err := api.checkResourceQuota(ccid, pod, replaced); err != nil {
+		kubernetesLogger.Errorf("Resource quota pre-flight check failed for chaincode pod %s. %s", podName, err)
+		if cleanupErr := api.deleteChainCodeFilesConfigMap(podName); cleanupErr != nil {
+			kubernetesLogger.Warningf("Failed to remove config maps of %s after failed pre-flight check: %s", podName, cleanupErr)
+		}
+		return nil, err
+	}
+
 	// Not already deployed so create it.
 	kubernetesLogger.Info("Creating chaincode peer pod deployment")
 	return api.client.Core().Pods(api.Namespace).Create(pod)
@@ -325,7 +609,7 @@ func getResourceRequest() (apiv1.ResourceRequirements, error) {
 		return fmt.Sprintf(keyPrefix, k)
 	}
 
-	setQuantityFromConfig := func(k apiv1.ResourceName) error {
+	setQuantityFromConfig := func(k apiv1.ResourceName, list apiv1.ResourceList, name apiv1.ResourceName) error {
 		// Read in (possibly non-existent) value from config.
 		qty, err := getResourceQuantity(key(k.String()))
 		if err != nil {
@@ -338,27 +622,32 @@ func getResourceRequest() (apiv1.ResourceRequirements, error) {
 		}
 
 		// If quantity is provided, add to resources request.
-		resourceRequest.Requests[k] = *qty
+		list[name] = *qty
 		return nil
 	}
 
-	// vm.kubernetes.container.limits.cpu
-	if err := setQuantityFromConfig(apiv1.ResourceLimitsCPU); err != nil {
-		return apiv1.ResourceRequirements{}, err
-	}
+	// Limits throttle or kill the chaincode container, so they are only applied when enforced.
+	if viper.GetBool(key("enforceLimits")) {
+		// vm.kubernetes.container.limits.cpu
+		if err := setQuantityFromConfig(apiv1.ResourceLimitsCPU, resourceRequest.Limits, apiv1.ResourceCPU); err != nil {
+			return apiv1.ResourceRequirements{}, err
+		}
 
-	// vm.kubernetes.container.limits.memory
-	if err := setQuantityFromConfig(apiv1.ResourceLimitsMemory); err != nil {
-		return apiv1.ResourceRequirements{}, err
+		// vm.kubernetes.container.limits.memory
+		if err := setQuantityFromConfig(apiv1.ResourceLimitsMemory, resourceRequest.Limits, apiv1.ResourceMemory); err != nil {
+			return apiv1.ResourceRequirements{}, err
+		}
+	} else if viper.GetString(key(apiv1.ResourceLimitsCPU.String())) != "" || viper.GetString(key(apiv1.ResourceLimitsMemory.String())) != "" {
+		kubernetesLogger.Warningf("Ignoring vm.kubernetes.container.limits, set vm.kubernetes.container.enforceLimits to apply them")
 	}
 
 	// vm.kubernetes.container.requests.cpu
-	if err := setQuantityFromConfig(apiv1.ResourceRequestsCPU); err != nil {
+	if err := setQuantityFromConfig(apiv1.ResourceRequestsCPU, resourceRequest.Requests, apiv1.ResourceCPU); err != nil {
 		return apiv1.ResourceRequirements{}, err
 	}
 
 	// vm.kubernetes.container.requests.memory
-	if err := setQuantityFromConfig(apiv1.ResourceRequestsMemory); err != nil {
+	if err := setQuantityFromConfig(apiv1.ResourceRequestsMemory, resourceRequest.Requests, apiv1.ResourceMemory); err != nil {
 		return apiv1.ResourceRequirements{}, err
 	}
 
@@ -366,7 +655,7 @@ func getResourceRequest() (apiv1.ResourceRequirements, error) {
 }
 
 // createChainCodeFilesConfigMap return the mount point to use with the create config map or an error if it could not be created.
-func (api *KubernetesAPI) createChainCodeFilesConfigMap(podName string, filesToUpload map[string][]byte) (string, *apiv1.ConfigMap, error) {
+func (api *KubernetesAPI) createChainCodeFilesConfigMap(ccid ccintf.CCID, podName string, filesToUpload map[string][]byte) (string, *apiv1.ConfigMap, error) {
 
 	rootPath, binaryData := api.extractCommonRoot(filesToUpload)
 
@@ -377,6 +666,7 @@ func (api *KubernetesAPI) createChainCodeFilesConfigMap(podName string, filesToU
 			Labels: map[string]string{
 				"peer-owner": api.PeerID,
 				"peercc":     podName,
+				"ccname":     ccid.Name,
 				"service":    "peer-chaincode",
 			},
 		},
@@ -399,11 +689,26 @@ func (api *KubernetesAPI) createChainCodeFilesConfigMap(podName string, filesToU
 	return rootPath, configmap, err
 }
 
-// deleteChainCodeFilesConfigMap removes the configuration map files associate with the peer chaincode deployment
+// deleteChainCodeFilesConfigMap removes the configuration map files (including any chunked config maps) associate
+// with the peer chaincode deployment
 func (api *KubernetesAPI) deleteChainCodeFilesConfigMap(podName string) error {
 	opt := metav1.DeleteOptions{}
 	kubernetesLogger.Infof("Removing config map '%s' for peer chaincode deployment", podName)
-	return api.client.CoreV1().ConfigMaps(api.Namespace).Delete(podName, &opt)
+	configMaps, err := api.client.CoreV1().ConfigMaps(api.Namespace).List(metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("peercc=%s", podName),
+	})
+	if err != nil {
+		return err
+	}
+	if len(configMaps.Items) == 0 {
+		return api.client.CoreV1().ConfigMaps(api.Namespace).Delete(podName, &opt)
+	}
+	for _, configMap := range configMaps.Items {
+		if err := api.client.CoreV1().ConfigMaps(api.Namespace).Delete(configMap.Name, &opt); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // extractCommonRoot looks at the list of files and returns the longest matching root path and an updated set of files with it removed.
@@ -450,16 +755,12 @@ func (api *KubernetesAPI) stopAllInternal(ccid ccintf.CCID) error {
 	}
 	for _, pod := range ccPods.Items {
 		kubernetesLogger.Infof("Removing existing chaincode pod %s", pod.Name)
+		// look for wait handle and close before the deletion is reported by the pod monitor.
+		api.chaincodes.CloseInstance(pod.Name, "")
+
 		err := api.client.Core().Pods(api.Namespace).Delete(pod.Name, &metav1.DeleteOptions{
 			GracePeriodSeconds: &grace,
 		})
-		// look for wait handle and close.
-		cc := api.chaincodes.GetInstance(pod.Name)
-		if cc != nil {
-			close(*cc)
-			api.chaincodes.RemoveInstance(pod.Name)
-		}
-
 		if err != nil {
 			return err
 		}
@@ -467,6 +768,79 @@ func (api *KubernetesAPI) stopAllInternal(ccid ccintf.CCID) error {
 	return api.deleteChainCodeFilesConfigMap(api.GetPodName(ccid))
 }
 
+// Purge removes all pods, config maps and secrets labeled as owned by this peer.  When ccName is not
+// empty only the resources belonging to that chaincode are removed.  Returns the number of resources removed.
+func (api *KubernetesAPI) Purge(ccName string) (int, error) {
+	labelExp := fmt.Sprintf("peer-owner=%s", api.PeerID)
+	if ccName != "" {
+		labelExp = fmt.Sprintf("%s, ccname=%s", labelExp, ccName)
+	}
+	listOptions := metav1.ListOptions{
+		LabelSelector: labelExp,
+	}
+	grace := int64(0)
+	removed := 0
+
+	pods, err := api.client.CoreV1().Pods(api.Namespace).List(listOptions)
+	if err != nil {
+		return removed, err
+	}
+	podNames := map[string]bool{}
+	for _, pod := range pods.Items {
+		podNames[api.GetPodName(ccintf.CCID{Name: ccName, Version: pod.Labels["ccver"]})] = true
+		kubernetesLogger.Infof("Purging chaincode pod %s", pod.Name)
+		err := api.client.CoreV1().Pods(api.Namespace).Delete(pod.Name, &metav1.DeleteOptions{
+			GracePeriodSeconds: &grace,
+		})
+		if err != nil {
+			return removed, err
+		}
+		removed++
+	}
+
+	// Config maps created before the ccname label was introduced are only labeled with the pod name, so they are
+	// listed for the whole peer and matched against the pod names of the purged versions.
+	configMaps, err := api.client.CoreV1().ConfigMaps(api.Namespace).List(metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("peer-owner=%s", api.PeerID),
+	})
+	if err != nil {
+		return removed, err
+	}
+	for _, configMap := range configMaps.Items {
+		if ccName != "" && !configMapOfChaincode(configMap.Labels, ccName, podNames) {
+			continue
+		}
+		kubernetesLogger.Infof("Purging chaincode configmap %s", configMap.Name)
+		if err := api.client.CoreV1().ConfigMaps(api.Namespace).Delete(configMap.Name, &metav1.DeleteOptions{}); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+
+	secrets, err := api.client.CoreV1().Secrets(api.Namespace).List(listOptions)
+	if err != nil {
+		return removed, err
+	}
+	for _, secret := range secrets.Items {
+		kubernetesLogger.Infof("Purging chaincode secret %s", secret.Name)
+		if err := api.client.CoreV1().Secrets(api.Namespace).Delete(secret.Name, &metav1.DeleteOptions{}); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+
+	return removed, nil
+}
+
+// configMapOfChaincode returns true if the config map labels belong to the named chaincode.  Config maps without a
+// ccname label must be labeled with one of podNames, as a name prefix would also match other chaincodes.
+func configMapOfChaincode(labels map[string]string, ccName string, podNames map[string]bool) bool {
+	if name, ok := labels["ccname"]; ok {
+		return name == ccName
+	}
+	return podNames[labels["peercc"]]
+}
+
 // FindPeerCCPods looks for pods associated with this peer assigned to the given chaincode
 func (api *KubernetesAPI) FindPeerCCPods(ccid ccintf.CCID) (*apiv1.PodList, error) {
 
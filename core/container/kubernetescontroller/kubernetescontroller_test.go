@@ -12,16 +12,28 @@ SPDX-License-Identifier: Apache 2.0
 package kubernetescontroller
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/hyperledger/fabric/common/metrics/metricsfakes"
+	"github.com/hyperledger/fabric/core/container/ccintf"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
 	apiv1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
 	"k8s.io/client-go/tools/cache"
 )
 
@@ -53,6 +65,818 @@ var _ = Describe("Extract Root", func() {
 	})
 })
 
+var _ = Describe("NewKubernetesAPI", func() {
+	var (
+		attempts int
+		origFn   getClient
+	)
+
+	BeforeEach(func() {
+		attempts = 0
+		origFn = newClient
+		newClient = func() (kubernetes.Interface, error) {
+			attempts++
+			return nil, errors.New("api unavailable")
+		}
+		viper.Set("vm.kubernetes.client.retries", 2)
+		viper.Set("vm.kubernetes.client.retryInterval", time.Millisecond)
+		resetSharedClient()
+	})
+
+	AfterEach(func() {
+		newClient = origFn
+		viper.Set("vm.kubernetes.client.retries", nil)
+		viper.Set("vm.kubernetes.client.retryInterval", nil)
+		viper.Set("vm.kubernetes.client.failureBackoff", nil)
+		resetSharedClient()
+	})
+
+	It("returns an error instead of panicking when the client cannot be created", func() {
+		api, err := NewKubernetesAPI("peer", "network", NewExitHandles())
+		Expect(err).To(MatchError("failed to create kubernetes client after 3 attempts: api unavailable"))
+		Expect(api).To(BeNil())
+		Expect(attempts).To(Equal(3))
+	})
+
+	It("succeeds once the client can be created", func() {
+		newClient = func() (kubernetes.Interface, error) {
+			attempts++
+			if attempts < 2 {
+				return nil, errors.New("api unavailable")
+			}
+			return &kubernetes.Clientset{}, nil
+		}
+		api, err := NewKubernetesAPI("peer", "network", NewExitHandles())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(api.PeerID).To(Equal("peer"))
+		Expect(attempts).To(Equal(2))
+
+		// The client is shared by later instances.
+		other, err := NewKubernetesAPI("peer", "network", NewExitHandles())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(other.client).To(BeIdenticalTo(api.client))
+		Expect(attempts).To(Equal(2))
+	})
+
+	It("does not retry when the client configuration is invalid", func() {
+		newClient = func() (kubernetes.Interface, error) {
+			attempts++
+			return nil, configError{errors.New("not running in a pod")}
+		}
+		_, err := NewKubernetesAPI("peer", "network", NewExitHandles())
+		Expect(err).To(MatchError("failed to create kubernetes client: not running in a pod"))
+		Expect(attempts).To(Equal(1))
+	})
+
+	It("does not block other callers while waiting to retry", func() {
+		var mutex sync.Mutex
+		newClient = func() (kubernetes.Interface, error) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			attempts++
+			if attempts == 1 {
+				return nil, errors.New("api unavailable")
+			}
+			return &kubernetes.Clientset{}, nil
+		}
+		viper.Set("vm.kubernetes.client.retries", 1)
+		viper.Set("vm.kubernetes.client.retryInterval", 500*time.Millisecond)
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			NewKubernetesAPI("peer", "network", NewExitHandles())
+		}()
+		Eventually(func() int {
+			mutex.Lock()
+			defer mutex.Unlock()
+			return attempts
+		}).Should(Equal(1))
+
+		start := time.Now()
+		_, err := NewKubernetesAPI("peer", "network", NewExitHandles())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(time.Since(start)).To(BeNumerically("<", 250*time.Millisecond))
+		Eventually(done).Should(BeClosed())
+	})
+
+	It("fails fast during the backoff after the client could not be created", func() {
+		viper.Set("vm.kubernetes.client.failureBackoff", 50*time.Millisecond)
+
+		_, err := NewKubernetesAPI("peer", "network", NewExitHandles())
+		Expect(err).To(HaveOccurred())
+		Expect(attempts).To(Equal(3))
+
+		_, err = NewKubernetesAPI("peer", "network", NewExitHandles())
+		Expect(err).To(MatchError("failed to create kubernetes client after 3 attempts: api unavailable"))
+		Expect(attempts).To(Equal(3))
+
+		time.Sleep(60 * time.Millisecond)
+		_, err = NewKubernetesAPI("peer", "network", NewExitHandles())
+		Expect(err).To(HaveOccurred())
+		Expect(attempts).To(Equal(6))
+	})
+})
+
+func resetSharedClient() {
+	sharedClient.Lock()
+	defer sharedClient.Unlock()
+	sharedClient.client = nil
+	sharedClient.err = nil
+	sharedClient.retryAfter = time.Time{}
+}
+
+var _ = Describe("Purge", func() {
+	var api *KubernetesAPI
+
+	labels := func(owner, ccname string) map[string]string {
+		return map[string]string{"peer-owner": owner, "ccname": ccname, "ccver": "1.0"}
+	}
+
+	BeforeEach(func() {
+		api = &KubernetesAPI{
+			PeerID:    "peer0",
+			Namespace: "ns",
+			client: fake.NewSimpleClientset(
+				&apiv1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "cc-peer0-a", Namespace: "ns", Labels: labels("peer0", "a")}},
+				&apiv1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "cc-peer0-b", Namespace: "ns", Labels: labels("peer0", "b")}},
+				&apiv1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "cc-peer1-a", Namespace: "ns", Labels: labels("peer1", "a")}},
+				&apiv1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "cc-peer0-a", Namespace: "ns", Labels: labels("peer0", "a")}},
+				&apiv1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "cc-peer0-b", Namespace: "ns", Labels: labels("peer0", "b")}},
+				// Created before config maps were labeled with ccname.
+				&apiv1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "cc-peer0-a-1.0", Namespace: "ns", Labels: map[string]string{"peer-owner": "peer0", "peercc": "cc-peer0-a-1.0"}}},
+				&apiv1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "cc-peer0-b-1.0", Namespace: "ns", Labels: map[string]string{"peer-owner": "peer0", "peercc": "cc-peer0-b-1.0"}}},
+				&apiv1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "cc-peer1-a-1.0", Namespace: "ns", Labels: map[string]string{"peer-owner": "peer1", "peercc": "cc-peer1-a-1.0"}}},
+			),
+			chaincodes: NewExitHandles(),
+		}
+	})
+
+	remainingPods := func() []string {
+		pods, err := api.client.CoreV1().Pods("ns").List(metav1.ListOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		var names []string
+		for _, p := range pods.Items {
+			names = append(names, p.Name)
+		}
+		return names
+	}
+
+	remainingConfigMaps := func() []string {
+		configMaps, err := api.client.CoreV1().ConfigMaps("ns").List(metav1.ListOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		var names []string
+		for _, c := range configMaps.Items {
+			names = append(names, c.Name)
+		}
+		return names
+	}
+
+	It("removes all resources owned by the peer", func() {
+		removed, err := api.Purge("")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(removed).To(Equal(6))
+		Expect(remainingPods()).To(ConsistOf("cc-peer1-a"))
+		Expect(remainingConfigMaps()).To(ConsistOf("cc-peer1-a-1.0"))
+	})
+
+	It("removes only the resources of the named chaincode", func() {
+		removed, err := api.Purge("a")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(removed).To(Equal(3))
+		Expect(remainingPods()).To(ConsistOf("cc-peer0-b", "cc-peer1-a"))
+		Expect(remainingConfigMaps()).To(ConsistOf("cc-peer0-b-1.0", "cc-peer1-a-1.0"))
+	})
+
+	It("leaves unlabeled config maps of chaincodes sharing the name prefix", func() {
+		_, err := api.client.CoreV1().Pods("ns").Create(&apiv1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "cc-peer0-a-extra", Namespace: "ns", Labels: labels("peer0", "a-extra")}})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = api.client.CoreV1().ConfigMaps("ns").Create(&apiv1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "cc-peer0-a-extra-1.0", Namespace: "ns", Labels: map[string]string{"peer-owner": "peer0", "peercc": "cc-peer0-a-extra-1.0"}}})
+		Expect(err).NotTo(HaveOccurred())
+
+		removed, err := api.Purge("a")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(removed).To(Equal(3))
+		Expect(remainingPods()).To(ConsistOf("cc-peer0-a-extra", "cc-peer0-b", "cc-peer1-a"))
+		Expect(remainingConfigMaps()).To(ConsistOf("cc-peer0-a-extra-1.0", "cc-peer0-b-1.0", "cc-peer1-a-1.0"))
+	})
+})
+
+var _ = Describe("Uploaded files", func() {
+	var api *KubernetesAPI
+
+	BeforeEach(func() {
+		api = &KubernetesAPI{
+			PeerID:     "peer0",
+			Namespace:  "ns",
+			client:     fake.NewSimpleClientset(),
+			chaincodes: NewExitHandles(),
+		}
+	})
+
+	// files returns a set of files which occupy exactly size bytes in a config map
+	files := func(size int) map[string][]byte {
+		key := "/etc/hyperledger/fabric/client.crt"
+		return map[string][]byte{
+			"/etc/hyperledger/fabric/peer.crt": []byte("peer"),
+			key:                                bytes.Repeat([]byte("x"), size-len("client.crt")-len("peer.crt")-len("peer")),
+		}
+	}
+
+	It("uses a single config map at the size limit", func() {
+		mountPoint, volumes, initContainers, err := api.createChainCodeFilesVolumes(ccintf.CCID{Name: "cc"}, "cc-peer0-cc", files(maxConfigMapSize))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mountPoint).To(Equal("/etc/hyperledger/fabric/"))
+		Expect(initContainers).To(BeEmpty())
+		Expect(volumes).To(HaveLen(1))
+		Expect(volumes[0].ConfigMap.Name).To(Equal("cc-peer0-cc"))
+	})
+
+	It("splits files across config maps beyond the size limit", func() {
+		uploaded := files(maxConfigMapSize + 1)
+		mountPoint, volumes, initContainers, err := api.createChainCodeFilesVolumes(ccintf.CCID{Name: "cc"}, "cc-peer0-cc", uploaded)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mountPoint).To(Equal("/etc/hyperledger/fabric/"))
+		Expect(volumes).To(HaveLen(3))
+		Expect(volumes[0].EmptyDir).NotTo(BeNil())
+		Expect(initContainers).To(HaveLen(1))
+		Expect(initContainers[0].Command[2]).To(Equal("set -e\n" +
+			"cat '/chunks/0/client.crt.0' > '/uploadedfiles/client.crt'\n" +
+			"cat '/chunks/1/peer.crt.0' > '/uploadedfiles/peer.crt'"))
+
+		configMaps, err := api.client.CoreV1().ConfigMaps("ns").List(metav1.ListOptions{LabelSelector: "peercc=cc-peer0-cc"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(configMaps.Items).To(HaveLen(2))
+		for _, cm := range configMaps.Items {
+			Expect(filesSize(cm.BinaryData)).To(BeNumerically("<=", maxConfigMapSize))
+			Expect(cm.Labels).To(HaveKeyWithValue("ccname", "cc"))
+		}
+
+		err = api.deleteChainCodeFilesConfigMap("cc-peer0-cc")
+		Expect(err).NotTo(HaveOccurred())
+		configMaps, err = api.client.CoreV1().ConfigMaps("ns").List(metav1.ListOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(configMaps.Items).To(BeEmpty())
+	})
+
+	It("removes the chunks already created when creating a chunk fails", func() {
+		client := api.client.(*fake.Clientset)
+		created := 0
+		client.PrependReactor("create", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			created++
+			if created == 2 {
+				return true, nil, errors.New("quota exceeded")
+			}
+			return false, nil, nil
+		})
+
+		_, _, _, err := api.createChainCodeFilesVolumes(ccintf.CCID{Name: "cc"}, "cc-peer0-cc", files(maxConfigMapSize+1))
+		Expect(err).To(MatchError("quota exceeded"))
+
+		configMaps, err := api.client.CoreV1().ConfigMaps("ns").List(metav1.ListOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(configMaps.Items).To(BeEmpty())
+	})
+
+	It("splits a single file across chunks and reassembles it", func() {
+		data := []byte("0123456789abcdefghij")
+		chunks, parts := chunkFiles(map[string][]byte{"f": data, "e": nil}, 10)
+		Expect(chunks).To(HaveLen(4))
+
+		var assembled []byte
+		for _, p := range parts["f"] {
+			assembled = append(assembled, chunks[p.chunk][p.key]...)
+		}
+		Expect(assembled).To(Equal(data))
+		Expect(parts["e"]).To(Equal([]chunkPart{{chunk: 0, key: "e.0"}}))
+		for _, chunk := range chunks {
+			Expect(filesSize(chunk)).To(BeNumerically("<=", 10))
+		}
+	})
+})
+
+type waitResult struct {
+	code int
+	err  error
+}
+
+// waitAsync calls Wait for the chaincode in the background, returning once the calling goroutine runs.
+func waitAsync(api *KubernetesAPI, ccid ccintf.CCID) <-chan waitResult {
+	started := make(chan struct{})
+	result := make(chan waitResult, 1)
+	go func() {
+		close(started)
+		code, err := api.Wait(ccid)
+		result <- waitResult{code: code, err: err}
+	}()
+	<-started
+	return result
+}
+
+var _ = Describe("Wait for pod ready", func() {
+	var (
+		api     *KubernetesAPI
+		watcher *watch.FakeWatcher
+		pod     *apiv1.Pod
+	)
+
+	BeforeEach(func() {
+		client := fake.NewSimpleClientset()
+		watcher = watch.NewFake()
+		client.PrependWatchReactor("pods", k8stesting.DefaultWatchReactor(watcher, nil))
+		api = &KubernetesAPI{
+			PeerID:     "peer0",
+			Namespace:  "ns",
+			client:     client,
+			chaincodes: NewExitHandles(),
+		}
+		pod = &apiv1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "cc-peer0-cc", Namespace: "ns", UID: "uid", ResourceVersion: "1"}}
+	})
+
+	It("returns once the pod is running and ready", func() {
+		ready := pod.DeepCopy()
+		ready.Status.Phase = apiv1.PodRunning
+		ready.Status.Conditions = []apiv1.PodCondition{{Type: apiv1.PodReady, Status: apiv1.ConditionTrue}}
+		go func() {
+			running := pod.DeepCopy()
+			running.Status.Phase = apiv1.PodRunning
+			watcher.Modify(running)
+			watcher.Modify(ready)
+		}()
+		Expect(api.waitForPodReady(pod, time.Minute, 5)).To(Succeed())
+	})
+
+	It("returns an error when the pod fails", func() {
+		failed := pod.DeepCopy()
+		failed.Status.Phase = apiv1.PodFailed
+		failed.Status.Message = "boom"
+		go watcher.Modify(failed)
+		Expect(api.waitForPodReady(pod, time.Minute, 5)).To(MatchError("pod cc-peer0-cc exited with phase Failed: boom"))
+	})
+
+	It("returns an error when the image cannot be pulled", func() {
+		pulling := pod.DeepCopy()
+		pulling.Status.ContainerStatuses = []apiv1.ContainerStatus{{
+			Image: "cc:1.0",
+			State: apiv1.ContainerState{Waiting: &apiv1.ContainerStateWaiting{Reason: "ImagePullBackOff", Message: "not found"}},
+		}}
+		go watcher.Modify(pulling)
+		Expect(api.waitForPodReady(pod, time.Minute, 5)).To(MatchError("pod cc-peer0-cc cannot pull image cc:1.0: not found"))
+	})
+
+	It("returns an error when the pod is deleted", func() {
+		go watcher.Delete(pod.DeepCopy())
+		Expect(api.waitForPodReady(pod, time.Minute, 5)).To(MatchError("pod cc-peer0-cc was deleted before it became ready"))
+	})
+
+	It("returns an error when the containers restart too often", func() {
+		looping := pod.DeepCopy()
+		looping.Status.Phase = apiv1.PodRunning
+		looping.Status.ContainerStatuses = []apiv1.ContainerStatus{{Name: "cc", RestartCount: 6}}
+		go watcher.Modify(looping)
+		Expect(api.waitForPodReady(pod, time.Minute, 5)).To(MatchError("pod cc-peer0-cc container cc restarted 6 times, exceeding the limit of 5"))
+	})
+
+	It("closes the exit handle when a running pod crash loops", func() {
+		ccid := ccintf.CCID{Name: "cc"}
+		ccchan := make(chan string, 1)
+		api.chaincodes.SetInstance("cc-peer0-cc", &ccchan)
+
+		go api.monitorPod(pod, 2)
+		restarting := pod.DeepCopy()
+		restarting.Status.ContainerStatuses = []apiv1.ContainerStatus{{Name: "cc", RestartCount: 2}}
+		watcher.Modify(restarting)
+		looping := restarting.DeepCopy()
+		looping.Status.ContainerStatuses[0].RestartCount = 3
+		watcher.Modify(looping)
+
+		Expect(<-ccchan).To(Equal("pod cc-peer0-cc container cc restarted 3 times, exceeding the limit of 2"))
+		Eventually(func() *chan string { return api.chaincodes.GetInstance("cc-peer0-cc") }).Should(BeNil())
+
+		ccchan = make(chan string, 1)
+		api.chaincodes.SetInstance("cc-peer0-cc", &ccchan)
+		ccchan <- "crashed"
+		_, err := api.Wait(ccid)
+		Expect(err).To(MatchError("chaincode cc-peer0-cc exited: crashed"))
+	})
+
+	It("resynchronizes instead of rewatching an expired resource version", func() {
+		defer func(orig time.Duration) { watchRetryInterval = orig }(watchRetryInterval)
+		watchRetryInterval = time.Millisecond
+
+		client := fake.NewSimpleClientset(pod.DeepCopy())
+		var mutex sync.Mutex
+		var watched []string
+		client.PrependWatchReactor("pods", func(action k8stesting.Action) (bool, watch.Interface, error) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			watched = append(watched, action.(k8stesting.WatchActionImpl).WatchRestrictions.ResourceVersion)
+			if len(watched) == 1 {
+				return true, nil, apierrors.NewGone("too old resource version: 1")
+			}
+			return true, watcher, nil
+		})
+		api.client = client
+
+		ccchan := make(chan string, 1)
+		api.chaincodes.SetInstance("cc-peer0-cc", &ccchan)
+		go api.monitorPod(pod, 2)
+
+		looping := pod.DeepCopy()
+		looping.Status.ContainerStatuses = []apiv1.ContainerStatus{{Name: "cc", RestartCount: 3}}
+		watcher.Modify(looping)
+
+		Eventually(ccchan).Should(Receive(Equal("pod cc-peer0-cc container cc restarted 3 times, exceeding the limit of 2")))
+		mutex.Lock()
+		defer mutex.Unlock()
+		Expect(watched).To(HaveLen(2))
+		Expect(watched[0]).To(Equal("1"))
+	})
+
+	It("returns from Wait without an error when the chaincode completes", func() {
+		ccid := ccintf.CCID{Name: "cc"}
+		ccchan := make(chan string, 1)
+		api.chaincodes.SetInstance("cc-peer0-cc", &ccchan)
+
+		go api.monitorPod(pod, 2)
+		exited := waitAsync(api, ccid)
+		running := pod.DeepCopy()
+		running.Status.Phase = apiv1.PodRunning
+		watcher.Modify(running)
+		succeeded := pod.DeepCopy()
+		succeeded.Status.Phase = apiv1.PodSucceeded
+		watcher.Modify(succeeded)
+
+		result := <-exited
+		Expect(result.err).NotTo(HaveOccurred())
+		Expect(result.code).To(Equal(0))
+		Expect(api.chaincodes.GetInstance("cc-peer0-cc")).To(BeNil())
+	})
+
+	It("returns an error from Wait when the chaincode fails", func() {
+		ccid := ccintf.CCID{Name: "cc"}
+		ccchan := make(chan string, 1)
+		api.chaincodes.SetInstance("cc-peer0-cc", &ccchan)
+
+		go api.monitorPod(pod, 2)
+		exited := waitAsync(api, ccid)
+		running := pod.DeepCopy()
+		running.Status.Phase = apiv1.PodRunning
+		watcher.Modify(running)
+		failed := pod.DeepCopy()
+		failed.Status.Phase = apiv1.PodFailed
+		watcher.Modify(failed)
+
+		Expect((<-exited).err).To(MatchError("chaincode cc-peer0-cc exited: pod cc-peer0-cc exited with phase Failed"))
+	})
+
+	It("returns an error from Wait when the pod cannot be watched", func() {
+		defer func(orig time.Duration) { watchRetryInterval = orig }(watchRetryInterval)
+		watchRetryInterval = time.Millisecond
+
+		client := fake.NewSimpleClientset()
+		watches := 0
+		client.PrependWatchReactor("pods", func(action k8stesting.Action) (bool, watch.Interface, error) {
+			watches++
+			return true, nil, apierrors.NewForbidden(apiv1.Resource("pods"), "", errors.New("no watch permission"))
+		})
+		api.client = client
+
+		ccid := ccintf.CCID{Name: "cc"}
+		ccchan := make(chan string, 1)
+		api.chaincodes.SetInstance("cc-peer0-cc", &ccchan)
+		go api.monitorPod(pod, 2)
+
+		_, err := api.Wait(ccid)
+		Expect(err).To(MatchError(`chaincode cc-peer0-cc exited: unable to monitor pod cc-peer0-cc: pods is forbidden: no watch permission`))
+		Expect(watches).To(Equal(maxMonitorFailures))
+	})
+
+	It("closes the exit handle when the pod is deleted", func() {
+		ccchan := make(chan string, 1)
+		api.chaincodes.SetInstance("cc-peer0-cc", &ccchan)
+
+		go api.monitorPod(pod, 2)
+		other := pod.DeepCopy()
+		other.UID = "replaced"
+		watcher.Delete(other)
+		watcher.Delete(pod.DeepCopy())
+
+		Expect(<-ccchan).To(Equal("pod cc-peer0-cc was deleted"))
+	})
+
+	It("closes the exit handle when the pod is evicted", func() {
+		ccchan := make(chan string, 1)
+		api.chaincodes.SetInstance("cc-peer0-cc", &ccchan)
+
+		go api.monitorPod(pod, 2)
+		evicted := pod.DeepCopy()
+		evicted.Status.Phase = apiv1.PodFailed
+		evicted.Status.Reason = "Evicted"
+		evicted.Status.Message = "The node was low on resource: memory."
+		watcher.Modify(evicted)
+
+		Expect(<-ccchan).To(Equal("pod cc-peer0-cc was evicted: The node was low on resource: memory."))
+	})
+
+	It("times out", func() {
+		Expect(api.waitForPodReady(pod, 10*time.Millisecond, 5)).To(MatchError("timed out after 10ms waiting for pod cc-peer0-cc to become ready"))
+	})
+
+	It("removes the pod and exit handle when Start fails", func() {
+		viper.Set("vm.kubernetes.startTimeout", 10*time.Millisecond)
+		defer viper.Set("vm.kubernetes.startTimeout", nil)
+
+		ccid := ccintf.CCID{Name: "cc", Version: "1.0"}
+		err := api.Start(ccid, []string{"chaincode"}, nil, nil, nil)
+		Expect(err).To(MatchError("timed out after 10ms waiting for pod cc-peer0-cc-1.0 to become ready"))
+
+		pods, err := api.client.CoreV1().Pods("ns").List(metav1.ListOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pods.Items).To(BeEmpty())
+		Expect(api.chaincodes.GetInstance("cc-peer0-cc-1.0")).To(BeNil())
+	})
+})
+
+var _ = Describe("Restart policy", func() {
+	AfterEach(func() {
+		viper.Set("vm.kubernetes.restartPolicy", nil)
+	})
+
+	It("defaults to Never", func() {
+		policy, err := getRestartPolicy()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(policy).To(Equal(apiv1.RestartPolicyNever))
+	})
+
+	It("accepts the configured policy", func() {
+		viper.Set("vm.kubernetes.restartPolicy", "OnFailure")
+		policy, err := getRestartPolicy()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(policy).To(Equal(apiv1.RestartPolicyOnFailure))
+	})
+
+	It("rejects unknown policies", func() {
+		viper.Set("vm.kubernetes.restartPolicy", "Sometimes")
+		_, err := getRestartPolicy()
+		Expect(err).To(MatchError("invalid vm.kubernetes.restartPolicy 'Sometimes', must be one of Never, OnFailure or Always"))
+	})
+})
+
+var _ = Describe("Spot nodes", func() {
+	AfterEach(func() {
+		viper.Set("vm.kubernetes.spot.avoidance", nil)
+		viper.Set("vm.kubernetes.spot.nodeLabels", nil)
+	})
+
+	It("does not add node affinity by default", func() {
+		affinity, err := getSpotNodeAffinity()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(affinity).To(BeNil())
+	})
+
+	It("requires nodes without the spot labels", func() {
+		viper.Set("vm.kubernetes.spot.avoidance", "required")
+		viper.Set("vm.kubernetes.spot.nodeLabels", []string{"lifecycle=spot"})
+		affinity, err := getSpotNodeAffinity()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(affinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms).To(Equal([]apiv1.NodeSelectorTerm{{
+			MatchExpressions: []apiv1.NodeSelectorRequirement{{Key: "lifecycle", Operator: apiv1.NodeSelectorOpNotIn, Values: []string{"spot"}}},
+		}}))
+	})
+
+	It("rejects invalid configuration", func() {
+		viper.Set("vm.kubernetes.spot.avoidance", "always")
+		_, err := getSpotNodeAffinity()
+		Expect(err).To(MatchError("invalid vm.kubernetes.spot.avoidance 'always', must be one of none, preferred or required"))
+
+		viper.Set("vm.kubernetes.spot.avoidance", "preferred")
+		viper.Set("vm.kubernetes.spot.nodeLabels", []string{"lifecycle"})
+		_, err = getSpotNodeAffinity()
+		Expect(err).To(MatchError("invalid vm.kubernetes.spot.nodeLabels entry 'lifecycle', expected key=value"))
+	})
+
+	It("relaunches chaincode removed from nodes being preempted elsewhere", func() {
+		pod := func(name, ccName, node string) *apiv1.Pod {
+			return &apiv1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns", Labels: map[string]string{
+					"peer-owner": "peer0",
+					"ccname":     ccName,
+					"ccver":      "1.0",
+				}},
+				Spec: apiv1.PodSpec{NodeName: node},
+			}
+		}
+		client := fake.NewSimpleClientset(pod("cc-peer0-a-1.0", "a", "spot-1"), pod("cc-peer0-b-1.0", "b", "stable-1"))
+		watcher := watch.NewFake()
+		client.PrependWatchReactor("nodes", k8stesting.DefaultWatchReactor(watcher, nil))
+		api := &KubernetesAPI{PeerID: "peer0", Namespace: "ns", client: client, chaincodes: NewExitHandles()}
+		ccchan := make(chan string, 1)
+		api.chaincodes.SetInstance("cc-peer0-a-1.0", &ccchan)
+
+		relaunched := make(chan *apiv1.Pod, 1)
+		relaunch := func(ccName, ccVersion string) error {
+			pod, err := api.createChaincodePodDeployment(ccintf.CCID{Name: ccName, Version: ccVersion}, nil, nil, nil, nil)
+			relaunched <- pod
+			return err
+		}
+
+		stop := make(chan struct{})
+		done := make(chan struct{})
+		go func() {
+			api.WatchPreemption(stop, relaunch)
+			close(done)
+		}()
+		watcher.Modify(&apiv1.Node{ObjectMeta: metav1.ObjectMeta{Name: "stable-1"}})
+		watcher.Modify(&apiv1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "spot-1"},
+			Spec:       apiv1.NodeSpec{Taints: []apiv1.Taint{{Key: "aws-node-termination-handler/spot-itn"}}},
+		})
+
+		Expect(<-ccchan).To(Equal("node spot-1 is being preempted"))
+		var replacement *apiv1.Pod
+		Eventually(relaunched).Should(Receive(&replacement))
+		Expect(replacement).NotTo(BeNil())
+		Expect(replacement.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms).To(Equal([]apiv1.NodeSelectorTerm{{
+			MatchFields: []apiv1.NodeSelectorRequirement{{Key: "metadata.name", Operator: apiv1.NodeSelectorOpNotIn, Values: []string{"spot-1"}}},
+		}}))
+
+		// The node leaving the preempted state is schedulable again.
+		watcher.Delete(&apiv1.Node{ObjectMeta: metav1.ObjectMeta{Name: "spot-1"}})
+		Eventually(func() *apiv1.NodeAffinity { return excludePreemptingNodes(nil) }).Should(BeNil())
+		close(stop)
+		Eventually(done).Should(BeClosed())
+
+		pods, err := client.CoreV1().Pods("ns").List(metav1.ListOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		names := []string{}
+		for _, p := range pods.Items {
+			names = append(names, p.Name)
+		}
+		Expect(names).To(ConsistOf("cc-peer0-a-1.0", "cc-peer0-b-1.0"))
+		Consistently(relaunched).ShouldNot(Receive())
+	})
+
+	It("adds the preempted nodes to every required node selector term", func() {
+		setNodePreempting("spot-2", true)
+		setNodePreempting("spot-1", true)
+		defer setNodePreempting("spot-1", false)
+		defer setNodePreempting("spot-2", false)
+
+		viper.Set("vm.kubernetes.spot.avoidance", "required")
+		viper.Set("vm.kubernetes.spot.nodeLabels", []string{"lifecycle=spot"})
+		affinity, err := getSpotNodeAffinity()
+		Expect(err).NotTo(HaveOccurred())
+		affinity = excludePreemptingNodes(affinity)
+		Expect(affinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms).To(Equal([]apiv1.NodeSelectorTerm{{
+			MatchExpressions: []apiv1.NodeSelectorRequirement{{Key: "lifecycle", Operator: apiv1.NodeSelectorOpNotIn, Values: []string{"spot"}}},
+			MatchFields:      []apiv1.NodeSelectorRequirement{{Key: "metadata.name", Operator: apiv1.NodeSelectorOpNotIn, Values: []string{"spot-1", "spot-2"}}},
+		}}))
+	})
+})
+
+var _ = Describe("Resource quota pre-flight", func() {
+	var (
+		api       *KubernetesAPI
+		resources apiv1.ResourceRequirements
+		quota     *apiv1.ResourceQuota
+		failures  *metricsfakes.Counter
+	)
+
+	BeforeEach(func() {
+		quota = &apiv1.ResourceQuota{
+			ObjectMeta: metav1.ObjectMeta{Name: "compute", Namespace: "ns"},
+			Status: apiv1.ResourceQuotaStatus{
+				Hard: apiv1.ResourceList{
+					apiv1.ResourceRequestsMemory: resource.MustParse("1Gi"),
+					apiv1.ResourcePods:           resource.MustParse("10"),
+				},
+				Used: apiv1.ResourceList{
+					apiv1.ResourceRequestsMemory: resource.MustParse("768Mi"),
+					apiv1.ResourcePods:           resource.MustParse("3"),
+				},
+			},
+		}
+		failures = &metricsfakes.Counter{}
+		failures.WithReturns(failures)
+		api = &KubernetesAPI{
+			PeerID:       "peer0",
+			Namespace:    "ns",
+			client:       fake.NewSimpleClientset(quota),
+			chaincodes:   NewExitHandles(),
+			BuildMetrics: &BuildMetrics{QuotaPreflightFailures: failures},
+		}
+		resources = apiv1.ResourceRequirements{
+			Requests: apiv1.ResourceList{apiv1.ResourceMemory: resource.MustParse("256Mi")},
+		}
+	})
+
+	chaincodePod := func(resources apiv1.ResourceRequirements) *apiv1.Pod {
+		return &apiv1.Pod{Spec: apiv1.PodSpec{Containers: []apiv1.Container{{Name: "cc", Resources: resources}}}}
+	}
+
+	It("passes when the remaining quota is sufficient", func() {
+		Expect(api.checkResourceQuota(ccintf.CCID{Name: "cc", Version: "1.0"}, chaincodePod(resources), nil)).To(Succeed())
+		Expect(failures.AddCallCount()).To(Equal(0))
+	})
+
+	It("returns an actionable error when the quota is exhausted", func() {
+		resources.Requests[apiv1.ResourceMemory] = resource.MustParse("512Mi")
+		err := api.checkResourceQuota(ccintf.CCID{Name: "cc", Version: "1.0"}, chaincodePod(resources), nil)
+		Expect(err).To(MatchError("resource quota compute in namespace ns does not allow chaincode cc-1.0 to start: requires 512Mi requests.memory but only 256Mi of 1Gi remains"))
+		Expect(failures.AddCallCount()).To(Equal(1))
+		Expect(failures.WithArgsForCall(0)).To(Equal([]string{"chaincode", "cc:1.0", "resource", "requests.memory"}))
+	})
+
+	It("considers the resources of the replaced pods available", func() {
+		resources.Requests[apiv1.ResourceMemory] = resource.MustParse("512Mi")
+		running := chaincodePod(apiv1.ResourceRequirements{
+			Requests: apiv1.ResourceList{apiv1.ResourceMemory: resource.MustParse("256Mi")},
+		})
+		Expect(api.checkResourceQuota(ccintf.CCID{Name: "cc", Version: "1.0"}, chaincodePod(resources), []apiv1.Pod{*running})).To(Succeed())
+
+		// Terminated pods are not counted by the quota in the first place.
+		failed := running.DeepCopy()
+		failed.Status.Phase = apiv1.PodFailed
+		err := api.checkResourceQuota(ccintf.CCID{Name: "cc", Version: "1.0"}, chaincodePod(resources), []apiv1.Pod{*failed})
+		Expect(err).To(MatchError("resource quota compute in namespace ns does not allow chaincode cc-1.0 to start: requires 512Mi requests.memory but only 256Mi of 1Gi remains"))
+	})
+
+	It("lets Start replace a running pod counted by the quota", func() {
+		viper.Set("vm.kubernetes.container.requests.memory", "512Mi")
+		viper.Set("vm.kubernetes.startTimeout", 10*time.Millisecond)
+		defer viper.Set("vm.kubernetes.container.requests.memory", nil)
+		defer viper.Set("vm.kubernetes.startTimeout", nil)
+
+		ccid := ccintf.CCID{Name: "cc", Version: "1.0"}
+		old := chaincodePod(resources)
+		old.ObjectMeta = metav1.ObjectMeta{Name: "cc-peer0-cc-1.0", Namespace: "ns", Labels: map[string]string{
+			"peer-owner": "peer0",
+			"ccname":     "cc",
+			"ccver":      "1.0",
+		}}
+		_, err := api.client.CoreV1().Pods("ns").Create(old)
+		Expect(err).NotTo(HaveOccurred())
+
+		// The new pod passes the pre-flight check and only fails to become ready.
+		err = api.Start(ccid, []string{"chaincode"}, nil, nil, nil)
+		Expect(err).To(MatchError("timed out after 10ms waiting for pod cc-peer0-cc-1.0 to become ready"))
+		Expect(failures.AddCallCount()).To(Equal(0))
+	})
+
+	It("accounts for the largest init container", func() {
+		pod := chaincodePod(resources)
+		pod.Spec.InitContainers = []apiv1.Container{{
+			Name:      "assemble-uploadedfiles",
+			Resources: apiv1.ResourceRequirements{Requests: apiv1.ResourceList{apiv1.ResourceMemory: resource.MustParse("384Mi")}},
+		}}
+		err := api.checkResourceQuota(ccintf.CCID{Name: "cc", Version: "1.0"}, pod, nil)
+		Expect(err).To(MatchError("resource quota compute in namespace ns does not allow chaincode cc-1.0 to start: requires 384Mi requests.memory but only 256Mi of 1Gi remains"))
+
+		Expect(podQuotaUsage(&apiv1.PodSpec{
+			Containers:     []apiv1.Container{pod.Spec.Containers[0], pod.Spec.Containers[0]},
+			InitContainers: pod.Spec.InitContainers,
+		})[apiv1.ResourceRequestsMemory]).To(Equal(resource.MustParse("512Mi")))
+	})
+
+	It("reports the exhausted resources in a stable order", func() {
+		quota.Status.Used[apiv1.ResourcePods] = resource.MustParse("10")
+		_, err := api.client.CoreV1().ResourceQuotas("ns").Update(quota)
+		Expect(err).NotTo(HaveOccurred())
+		resources.Requests[apiv1.ResourceMemory] = resource.MustParse("512Mi")
+		for i := 0; i < 10; i++ {
+			err := api.checkResourceQuota(ccintf.CCID{Name: "cc", Version: "1.0"}, chaincodePod(resources), nil)
+			Expect(err).To(MatchError("resource quota compute in namespace ns does not allow chaincode cc-1.0 to start: requires 1 pods but only 0 of 10 remains"))
+		}
+	})
+
+	It("reads limits and requests from config", func() {
+		viper.Set("vm.kubernetes.container.enforceLimits", true)
+		viper.Set("vm.kubernetes.container.limits.memory", "1Gi")
+		viper.Set("vm.kubernetes.container.requests.cpu", "250m")
+		defer viper.Set("vm.kubernetes.container.enforceLimits", nil)
+		defer viper.Set("vm.kubernetes.container.limits.memory", nil)
+		defer viper.Set("vm.kubernetes.container.requests.cpu", nil)
+
+		resources, err := getResourceRequest()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resources.Limits).To(Equal(apiv1.ResourceList{apiv1.ResourceMemory: resource.MustParse("1Gi")}))
+		Expect(resources.Requests).To(Equal(apiv1.ResourceList{apiv1.ResourceCPU: resource.MustParse("250m")}))
+	})
+
+	It("only sets requests unless limits are enforced", func() {
+		viper.Set("vm.kubernetes.container.limits.memory", "1Gi")
+		viper.Set("vm.kubernetes.container.requests.memory", "512Mi")
+		defer viper.Set("vm.kubernetes.container.limits.memory", nil)
+		defer viper.Set("vm.kubernetes.container.requests.memory", nil)
+
+		resources, err := getResourceRequest()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resources.Limits).To(BeEmpty())
+		Expect(resources.Requests).To(Equal(apiv1.ResourceList{apiv1.ResourceMemory: resource.MustParse("512Mi")}))
+	})
+})
+
 // TestFakeClient demonstrates how to use a fake client with SharedInformerFactory in tests.
 func TestFakeClient(t *testing.T) {
 	// Use a timeout to keep the test from hanging.
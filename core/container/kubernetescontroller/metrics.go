@@ -20,14 +20,23 @@ var (
 		LabelNames:   []string{"chaincode", "success"},
 		StatsdFormat: "%{#fqname}.%{chaincode}.%{success}",
 	}
+	quotaPreflightFailures = metrics.CounterOpts{
+		Namespace:    "kubernetescontroller",
+		Name:         "quota_preflight_failures",
+		Help:         "The number of chaincode pods not created because the namespace resource quota was exhausted.",
+		LabelNames:   []string{"chaincode", "resource"},
+		StatsdFormat: "%{#fqname}.%{chaincode}.%{resource}",
+	}
 )
 
 type BuildMetrics struct {
 	ChaincodeImageBuildDuration metrics.Histogram
+	QuotaPreflightFailures      metrics.Counter
 }
 
 func NewBuildMetrics(p metrics.Provider) *BuildMetrics {
 	return &BuildMetrics{
 		ChaincodeImageBuildDuration: p.NewHistogram(chaincodeImageBuildDuration),
+		QuotaPreflightFailures:      p.NewCounter(quotaPreflightFailures),
 	}
 }
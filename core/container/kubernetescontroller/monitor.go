@@ -0,0 +1,154 @@
+/*
+Copyright 2018 Figure Technoclogies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: BSD-3-Clause-Attribution
+
+*/
+
+package kubernetescontroller
+
+import (
+	"fmt"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// watchRetryInterval is the pause before a pod watch which failed or was expired is restarted, so an API server
+// repeatedly answering 410 Gone does not turn the monitor into a hot loop.
+var watchRetryInterval = time.Second
+
+// maxMonitorFailures is the number of consecutive failures to get or watch a pod after which the monitor gives up
+// and closes the exit handle, rather than leaving Wait blocked on a pod which is no longer observed.
+var maxMonitorFailures = 5
+
+// checkRestarts returns an error if any container in the pod has restarted more than maxRestarts times.
+func checkRestarts(pod *apiv1.Pod, maxRestarts int) error {
+	for _, cs := range pod.Status.ContainerStatuses {
+		if int(cs.RestartCount) > maxRestarts {
+			return fmt.Errorf("pod %s container %s restarted %d times, exceeding the limit of %d", pod.Name, cs.Name, cs.RestartCount, maxRestarts)
+		}
+	}
+	return nil
+}
+
+// exitReason reports whether the chaincode in the pod is no longer running, with the reason unless the chaincode
+// exited successfully.
+func exitReason(pod *apiv1.Pod, maxRestarts int) (bool, string) {
+	switch pod.Status.Phase {
+	case apiv1.PodFailed, apiv1.PodSucceeded:
+		if pod.Status.Reason == "Evicted" {
+			return true, fmt.Sprintf("pod %s was evicted: %s", pod.Name, pod.Status.Message)
+		}
+		if pod.Status.Phase == apiv1.PodSucceeded {
+			return true, ""
+		}
+		return true, fmt.Sprintf("pod %s exited with phase %s", pod.Name, pod.Status.Phase)
+	}
+	if err := checkRestarts(pod, maxRestarts); err != nil {
+		return true, err.Error()
+	}
+	return false, ""
+}
+
+// monitorPod watches a running chaincode pod and closes its exit handle once the pod is deleted, evicted or exits,
+// or once its containers have restarted more than maxRestarts times, so that Wait returns and the peer no longer
+// believes the chaincode is running.  The handle is closed without a reason when the chaincode exited successfully.
+// Monitoring ends when the exit handle is closed.
+func (api *KubernetesAPI) monitorPod(pod *apiv1.Pod, maxRestarts int) {
+	handle := api.chaincodes.GetInstance(pod.Name)
+	resourceVersion := pod.ResourceVersion
+	failures := 0
+
+	exit := func(reason string) {
+		if reason != "" {
+			kubernetesLogger.Warningf("Chaincode pod %s is no longer running: %s", pod.Name, reason)
+		} else {
+			kubernetesLogger.Infof("Chaincode pod %s completed", pod.Name)
+		}
+		api.chaincodes.closeInstance(pod.Name, handle, reason)
+	}
+	// failed returns true once monitoring has failed too often in a row and the exit handle was closed.
+	failed := func(err error) bool {
+		failures++
+		if failures >= maxMonitorFailures {
+			exit(fmt.Sprintf("unable to monitor pod %s: %s", pod.Name, err))
+			return true
+		}
+		time.Sleep(watchRetryInterval)
+		return false
+	}
+
+	for handle != nil && api.chaincodes.GetInstance(pod.Name) == handle {
+		if resourceVersion == "" {
+			// Resynchronize after the watch failed, events may have been missed.
+			current, err := api.client.CoreV1().Pods(api.Namespace).Get(pod.Name, metav1.GetOptions{})
+			if errors.IsNotFound(err) || (err == nil && current.UID != pod.UID) {
+				exit(fmt.Sprintf("pod %s was deleted", pod.Name))
+				return
+			}
+			if err != nil {
+				kubernetesLogger.Errorf("Unable to get chaincode pod %s: %s", pod.Name, err)
+				if failed(err) {
+					return
+				}
+				continue
+			}
+			if exited, reason := exitReason(current, maxRestarts); exited {
+				exit(reason)
+				return
+			}
+			resourceVersion = current.ResourceVersion
+		}
+
+		w, err := api.client.CoreV1().Pods(api.Namespace).Watch(metav1.ListOptions{
+			FieldSelector:   fields.OneTermEqualSelector("metadata.name", pod.Name).String(),
+			ResourceVersion: resourceVersion,
+		})
+		if errors.IsGone(err) || errors.IsResourceExpired(err) {
+			// The resource version is no longer available, resynchronize before watching again.
+			kubernetesLogger.Warningf("Watch of chaincode pod %s expired: %s", pod.Name, err)
+			resourceVersion = ""
+			time.Sleep(watchRetryInterval)
+			continue
+		}
+		if err != nil {
+			kubernetesLogger.Errorf("Unable to watch chaincode pod %s: %s", pod.Name, err)
+			if failed(err) {
+				return
+			}
+			continue
+		}
+		failures = 0
+
+		for event := range w.ResultChan() {
+			if event.Type == watch.Error {
+				resourceVersion = ""
+				break
+			}
+			p, ok := event.Object.(*apiv1.Pod)
+			if !ok || p.Name != pod.Name || p.UID != pod.UID {
+				continue
+			}
+			resourceVersion = p.ResourceVersion
+
+			exited, reason := exitReason(p, maxRestarts)
+			if event.Type == watch.Deleted {
+				exited, reason = true, fmt.Sprintf("pod %s was deleted", pod.Name)
+			}
+			if exited {
+				w.Stop()
+				exit(reason)
+				return
+			}
+		}
+		w.Stop()
+		if resourceVersion == "" {
+			time.Sleep(watchRetryInterval)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2018 Figure Technoclogies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: BSD-3-Clause-Attribution
+
+*/
+
+package kubernetescontroller
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric/core/container/ccintf"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// podQuotaUsage returns the quota resources consumed by a pod.  Init containers run one at a time before the
+// containers start, so the pod accounts for the larger of any init container and the sum of its containers.
+func podQuotaUsage(spec *apiv1.PodSpec) apiv1.ResourceList {
+	requests, limits := apiv1.ResourceList{}, apiv1.ResourceList{}
+	for _, c := range spec.Containers {
+		addResources(requests, c.Resources.Requests)
+		addResources(limits, c.Resources.Limits)
+	}
+	for _, c := range spec.InitContainers {
+		maxResources(requests, c.Resources.Requests)
+		maxResources(limits, c.Resources.Limits)
+	}
+
+	usage := apiv1.ResourceList{
+		apiv1.ResourcePods: resource.MustParse("1"),
+	}
+	if q, ok := requests[apiv1.ResourceCPU]; ok {
+		usage[apiv1.ResourceCPU] = q
+		usage[apiv1.ResourceRequestsCPU] = q
+	}
+	if q, ok := requests[apiv1.ResourceMemory]; ok {
+		usage[apiv1.ResourceMemory] = q
+		usage[apiv1.ResourceRequestsMemory] = q
+	}
+	if q, ok := limits[apiv1.ResourceCPU]; ok {
+		usage[apiv1.ResourceLimitsCPU] = q
+	}
+	if q, ok := limits[apiv1.ResourceMemory]; ok {
+		usage[apiv1.ResourceLimitsMemory] = q
+	}
+	return usage
+}
+
+func addResources(total, resources apiv1.ResourceList) {
+	for name, q := range resources {
+		sum := total[name]
+		sum.Add(q)
+		total[name] = sum
+	}
+}
+
+func maxResources(total, resources apiv1.ResourceList) {
+	for name, q := range resources {
+		if current, ok := total[name]; !ok || q.Cmp(current) > 0 {
+			total[name] = q.DeepCopy()
+		}
+	}
+}
+
+// checkResourceQuota compares the resources the chaincode pod requires against what remains of each resource
+// quota in the namespace, returning an error naming the exhausted resource.  The usage of the pods being replaced
+// is considered available, as the quota may not have released it yet.  Quotas which cannot be read (for example
+// when the service account is not allowed to list them) are not checked.
+func (api *KubernetesAPI) checkResourceQuota(ccid ccintf.CCID, pod *apiv1.Pod, replaced []apiv1.Pod) error {
+	quotas, err := api.client.CoreV1().ResourceQuotas(api.Namespace).List(metav1.ListOptions{})
+	if err != nil {
+		kubernetesLogger.Warningf("Unable to list resource quotas in namespace %s, skipping pre-flight check: %s", api.Namespace, err)
+		return nil
+	}
+
+	released := apiv1.ResourceList{}
+	for i := range replaced {
+		// Quotas do not count pods which have terminated.
+		if phase := replaced[i].Status.Phase; phase == apiv1.PodSucceeded || phase == apiv1.PodFailed {
+			continue
+		}
+		addResources(released, podQuotaUsage(&replaced[i].Spec))
+	}
+
+	usage := podQuotaUsage(&pod.Spec)
+	names := make([]string, 0, len(usage))
+	for name := range usage {
+		names = append(names, string(name))
+	}
+	// Report the same exhausted resource on every attempt.
+	sort.Strings(names)
+
+	for _, quota := range quotas.Items {
+		hard := quota.Status.Hard
+		if len(hard) == 0 {
+			hard = quota.Spec.Hard
+		}
+
+		for _, n := range names {
+			name := apiv1.ResourceName(n)
+			required := usage[name]
+			limit, ok := hard[name]
+			if !ok {
+				continue
+			}
+			remaining := limit.DeepCopy()
+			if used, ok := quota.Status.Used[name]; ok {
+				remaining.Sub(used)
+			}
+			if q, ok := released[name]; ok {
+				remaining.Add(q)
+			}
+			if required.Cmp(remaining) <= 0 {
+				continue
+			}
+
+			if api.BuildMetrics != nil {
+				api.BuildMetrics.QuotaPreflightFailures.With("chaincode", ccid.Name+":"+ccid.Version, "resource", string(name)).Add(1)
+			}
+			return fmt.Errorf("resource quota %s in namespace %s does not allow chaincode %s to start: requires %s %s but only %s of %s remains",
+				quota.Name, api.Namespace, ccid.GetName(), required.String(), name, remaining.String(), limit.String())
+		}
+	}
+	return nil
+}
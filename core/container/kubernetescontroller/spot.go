@@ -0,0 +1,253 @@
+/*
+Copyright 2018 Figure Technoclogies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: BSD-3-Clause-Attribution
+
+*/
+
+package kubernetescontroller
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+var (
+	// defaultSpotNodeLabels identify spot/preemptible nodes on the common managed kubernetes offerings.
+	defaultSpotNodeLabels = []string{
+		"cloud.google.com/gke-preemptible=true",
+		"cloud.google.com/gke-spot=true",
+		"eks.amazonaws.com/capacityType=SPOT",
+		"kubernetes.azure.com/scalesetpriority=spot",
+	}
+
+	// defaultPreemptionTaints are applied to nodes which have received a preemption or termination notice.
+	defaultPreemptionTaints = []string{
+		"cloud.google.com/impending-node-termination",
+		"aws-node-termination-handler/spot-itn",
+	}
+
+	// preemptionRetryInterval is the delay before re-establishing a failed node watch.
+	preemptionRetryInterval = 10 * time.Second
+
+	// preemptingNodes holds the names of the nodes known to be preempted, which new chaincode pods must avoid.
+	preemptingNodes = struct {
+		sync.Mutex
+		names map[string]bool
+	}{names: map[string]bool{}}
+)
+
+// Relauncher launches the chaincode with the given name and version again through the peer, once its
+// instance has been removed.
+type Relauncher func(ccName, ccVersion string) error
+
+// excludePreemptingNodes adds the nodes being preempted to the required node affinity, as chaincode pods
+// can be created before the preemption taint takes effect on the scheduler.
+func excludePreemptingNodes(affinity *apiv1.NodeAffinity) *apiv1.NodeAffinity {
+	preemptingNodes.Lock()
+	names := make([]string, 0, len(preemptingNodes.names))
+	for name := range preemptingNodes.names {
+		names = append(names, name)
+	}
+	preemptingNodes.Unlock()
+
+	if len(names) == 0 {
+		return affinity
+	}
+	sort.Strings(names)
+
+	requirement := apiv1.NodeSelectorRequirement{
+		Key:      "metadata.name",
+		Operator: apiv1.NodeSelectorOpNotIn,
+		Values:   names,
+	}
+	if affinity == nil {
+		affinity = &apiv1.NodeAffinity{}
+	}
+	if affinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		affinity.RequiredDuringSchedulingIgnoredDuringExecution = &apiv1.NodeSelector{
+			NodeSelectorTerms: []apiv1.NodeSelectorTerm{{}},
+		}
+	}
+	// Terms are ORed, so each of them must exclude the nodes.
+	terms := affinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+	for i := range terms {
+		terms[i].MatchFields = append(terms[i].MatchFields, requirement)
+	}
+	return affinity
+}
+
+func setNodePreempting(name string, preempting bool) {
+	preemptingNodes.Lock()
+	defer preemptingNodes.Unlock()
+	if preempting {
+		preemptingNodes.names[name] = true
+	} else {
+		delete(preemptingNodes.names, name)
+	}
+}
+
+// getSpotNodeAffinity returns the node affinity keeping chaincode pods off spot nodes as configured by
+// vm.kubernetes.spot.avoidance ("preferred" or "required"), or nil when spot nodes are not avoided.
+func getSpotNodeAffinity() (*apiv1.NodeAffinity, error) {
+	avoidance := viper.GetString("vm.kubernetes.spot.avoidance")
+	if avoidance == "" || avoidance == "none" {
+		return nil, nil
+	}
+
+	labels := viper.GetStringSlice("vm.kubernetes.spot.nodeLabels")
+	if len(labels) == 0 {
+		labels = defaultSpotNodeLabels
+	}
+
+	// NotIn also matches nodes without the label, all expressions of a term must match.
+	term := apiv1.NodeSelectorTerm{}
+	for _, l := range labels {
+		kv := strings.SplitN(l, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid vm.kubernetes.spot.nodeLabels entry '%s', expected key=value", l)
+		}
+		term.MatchExpressions = append(term.MatchExpressions, apiv1.NodeSelectorRequirement{
+			Key:      kv[0],
+			Operator: apiv1.NodeSelectorOpNotIn,
+			Values:   []string{kv[1]},
+		})
+	}
+
+	switch avoidance {
+	case "preferred":
+		return &apiv1.NodeAffinity{
+			PreferredDuringSchedulingIgnoredDuringExecution: []apiv1.PreferredSchedulingTerm{
+				{
+					Weight:     100,
+					Preference: term,
+				},
+			},
+		}, nil
+	case "required":
+		return &apiv1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &apiv1.NodeSelector{
+				NodeSelectorTerms: []apiv1.NodeSelectorTerm{term},
+			},
+		}, nil
+	default:
+		return nil, fmt.Errorf("invalid vm.kubernetes.spot.avoidance '%s', must be one of none, preferred or required", avoidance)
+	}
+}
+
+// nodePreempting returns true if the node carries one of the preemption taints.
+func nodePreempting(node *apiv1.Node, taints []string) bool {
+	for _, t := range node.Spec.Taints {
+		for _, key := range taints {
+			if t.Key == key {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// WatchPreemption watches the cluster nodes for preemption notices (vm.kubernetes.spot.preemptionTaints) and
+// removes the chaincode pods owned by this peer from nodes about to be reclaimed.  The chaincode of removed pods
+// is launched again through relaunch, if not nil, on a node other than the preempted one; otherwise the peer
+// relaunches it on its next invocation.  Watching nodes requires the peer service account to be allowed to
+// list and watch nodes.  Returns when the stop channel is closed.
+func (api *KubernetesAPI) WatchPreemption(stop <-chan struct{}, relaunch Relauncher) {
+	taints := viper.GetStringSlice("vm.kubernetes.spot.preemptionTaints")
+	if len(taints) == 0 {
+		taints = defaultPreemptionTaints
+	}
+	evacuated := map[string]bool{}
+
+	for {
+		w, err := api.client.CoreV1().Nodes().Watch(metav1.ListOptions{})
+		if err != nil {
+			kubernetesLogger.Errorf("Unable to watch nodes for preemption: %s", err)
+		} else {
+			api.handleNodeEvents(w, taints, evacuated, relaunch, stop)
+			w.Stop()
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(preemptionRetryInterval):
+		}
+	}
+}
+
+// handleNodeEvents processes node events until the watch closes or the stop channel is closed.
+func (api *KubernetesAPI) handleNodeEvents(w watch.Interface, taints []string, evacuated map[string]bool, relaunch Relauncher, stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case event, ok := <-w.ResultChan():
+			if !ok {
+				return
+			}
+			node, ok := event.Object.(*apiv1.Node)
+			if !ok {
+				continue
+			}
+			if event.Type == watch.Deleted || !nodePreempting(node, taints) {
+				delete(evacuated, node.Name)
+				setNodePreempting(node.Name, false)
+				continue
+			}
+			if evacuated[node.Name] {
+				continue
+			}
+			kubernetesLogger.Warningf("Node %s is being preempted, relocating chaincode pods", node.Name)
+			setNodePreempting(node.Name, true)
+			if err := api.evacuateNode(node.Name, relaunch); err != nil {
+				kubernetesLogger.Errorf("Unable to relocate chaincode pods from node %s: %s", node.Name, err)
+				continue
+			}
+			evacuated[node.Name] = true
+		}
+	}
+}
+
+// evacuateNode deletes the chaincode pods owned by this peer running on the given node and relaunches
+// the chaincode of those the peer was connected to.
+func (api *KubernetesAPI) evacuateNode(nodeName string, relaunch Relauncher) error {
+	pods, err := api.client.CoreV1().Pods(api.Namespace).List(metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("peer-owner=%s", api.PeerID),
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", nodeName).String(),
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, pod := range pods.Items {
+		if pod.Spec.NodeName != nodeName {
+			continue
+		}
+		kubernetesLogger.Infof("Removing chaincode pod %s from preempted node %s", pod.Name, nodeName)
+		running := api.chaincodes.CloseInstance(pod.Name, fmt.Sprintf("node %s is being preempted", nodeName))
+		if err := api.client.CoreV1().Pods(api.Namespace).Delete(pod.Name, &metav1.DeleteOptions{}); err != nil {
+			return err
+		}
+
+		ccName, ccVersion := pod.Labels["ccname"], pod.Labels["ccver"]
+		if relaunch == nil || !running || ccName == "" {
+			continue
+		}
+		go func(podName string) {
+			if err := relaunch(ccName, ccVersion); err != nil {
+				kubernetesLogger.Errorf("Unable to relaunch chaincode pod %s removed from node %s: %s", podName, nodeName, err)
+			}
+		}(pod.Name)
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+/*
+Copyright 2018 Figure Technoclogies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: BSD-3-Clause-Attribution
+
+*/
+
+package kubernetescontroller
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/container/ccintf"
+	"github.com/spf13/viper"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// maxConfigMapSize is the total size of keys and values the API server accepts in a single ConfigMap.
+	maxConfigMapSize = 1024 * 1024
+
+	// defaultInitImage is used to reassemble chunked files when vm.kubernetes.initImage is not set.  The version is
+	// pinned so the chaincode start path does not depend on a mutable tag.
+	defaultInitImage = "busybox:1.31.1"
+
+	uploadedFilesVolume = "uploadedfiles-volume"
+	chunksMountPath     = "/chunks"
+	assembledMountPath  = "/uploadedfiles"
+)
+
+// createChainCodeFilesVolumes uploads the files for the chaincode pod and returns the mount point, the volumes and
+// any init containers required to present them in the chaincode container.  Files which fit in a single config map
+// are mounted from it directly, larger sets are split across several config maps and reassembled into an emptyDir
+// volume by an init container.
+func (api *KubernetesAPI) createChainCodeFilesVolumes(ccid ccintf.CCID, podName string, filesToUpload map[string][]byte) (string, []apiv1.Volume, []apiv1.Container, error) {
+	rootPath, binaryData := api.extractCommonRoot(filesToUpload)
+	if filesSize(binaryData) <= maxConfigMapSize {
+		mountPoint, configMap, err := api.createChainCodeFilesConfigMap(ccid, podName, filesToUpload)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		volumes := []apiv1.Volume{
+			{
+				Name: uploadedFilesVolume,
+				VolumeSource: apiv1.VolumeSource{
+					ConfigMap: &apiv1.ConfigMapVolumeSource{
+						LocalObjectReference: apiv1.LocalObjectReference{
+							Name: configMap.Name,
+						},
+					},
+				},
+			},
+		}
+		return mountPoint, volumes, nil, nil
+	}
+
+	chunks, parts := chunkFiles(binaryData, maxConfigMapSize)
+	kubernetesLogger.Infof("Chaincode files for %s exceed the config map size limit, splitting across %d config maps", podName, len(chunks))
+
+	volumes := []apiv1.Volume{
+		{
+			Name: uploadedFilesVolume,
+			VolumeSource: apiv1.VolumeSource{
+				EmptyDir: &apiv1.EmptyDirVolumeSource{},
+			},
+		},
+	}
+	mounts := []apiv1.VolumeMount{
+		{
+			Name:      uploadedFilesVolume,
+			MountPath: assembledMountPath,
+		},
+	}
+
+	for i, chunk := range chunks {
+		name := fmt.Sprintf("%s-%d", podName, i)
+		configMap := &apiv1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: api.Namespace,
+				Labels: map[string]string{
+					"peer-owner": api.PeerID,
+					"peercc":     podName,
+					"ccname":     ccid.Name,
+					"service":    "peer-chaincode",
+				},
+			},
+			BinaryData: chunk,
+		}
+		kubernetesLogger.Infof("Creating chaincode configmap '%s' for files", name)
+		if _, err := api.client.CoreV1().ConfigMaps(api.Namespace).Create(configMap); err != nil {
+			// Remove the chunks created so far rather than leaving them behind in the namespace.
+			if cleanupErr := api.deleteChainCodeFilesConfigMap(podName); cleanupErr != nil {
+				kubernetesLogger.Warningf("Failed to remove config maps of %s after failed creation: %s", podName, cleanupErr)
+			}
+			return "", nil, nil, err
+		}
+
+		volumeName := fmt.Sprintf("uploadedfiles-chunk-%d", i)
+		volumes = append(volumes, apiv1.Volume{
+			Name: volumeName,
+			VolumeSource: apiv1.VolumeSource{
+				ConfigMap: &apiv1.ConfigMapVolumeSource{
+					LocalObjectReference: apiv1.LocalObjectReference{
+						Name: name,
+					},
+				},
+			},
+		})
+		mounts = append(mounts, apiv1.VolumeMount{
+			Name:      volumeName,
+			MountPath: fmt.Sprintf("%s/%d", chunksMountPath, i),
+		})
+	}
+
+	initImage := viper.GetString("vm.kubernetes.initImage")
+	if initImage == "" {
+		initImage = defaultInitImage
+	}
+	initContainers := []apiv1.Container{
+		{
+			Name:         "assemble-uploadedfiles",
+			Image:        initImage,
+			Command:      []string{"sh", "-c", assembleScript(parts)},
+			VolumeMounts: mounts,
+		},
+	}
+
+	return rootPath, volumes, initContainers, nil
+}
+
+// filesSize returns the size the files occupy when stored in a config map.
+func filesSize(files map[string][]byte) int {
+	size := 0
+	for k, v := range files {
+		size += len(k) + len(v)
+	}
+	return size
+}
+
+// chunkPart locates one piece of an uploaded file within the chunked config maps.
+type chunkPart struct {
+	chunk int
+	key   string
+}
+
+// chunkFiles splits the files into config map payloads of at most limit bytes.  The returned parts map holds, for
+// each file, the ordered locations of its pieces.
+func chunkFiles(files map[string][]byte, limit int) ([]map[string][]byte, map[string][]chunkPart) {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	chunks := []map[string][]byte{{}}
+	parts := make(map[string][]chunkPart, len(files))
+	used := 0
+
+	for _, name := range names {
+		data := files[name]
+		for n := 0; n == 0 || len(data) > 0; n++ {
+			key := fmt.Sprintf("%s.%d", name, n)
+			// Start a new chunk if there is no room for the key and at least one byte of data.
+			if used+len(key) >= limit {
+				chunks = append(chunks, map[string][]byte{})
+				used = 0
+			}
+			size := limit - used - len(key)
+			if size > len(data) {
+				size = len(data)
+			}
+
+			current := len(chunks) - 1
+			chunks[current][key] = data[:size]
+			parts[name] = append(parts[name], chunkPart{chunk: current, key: key})
+			used += len(key) + size
+			data = data[size:]
+		}
+	}
+
+	return chunks, parts
+}
+
+// assembleScript builds the shell script run by the init container to concatenate the file pieces.
+func assembleScript(parts map[string][]chunkPart) string {
+	names := make([]string, 0, len(parts))
+	for name := range parts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	commands := []string{"set -e"}
+	for _, name := range names {
+		sources := make([]string, 0, len(parts[name]))
+		for _, p := range parts[name] {
+			sources = append(sources, fmt.Sprintf("'%s/%d/%s'", chunksMountPath, p.chunk, p.key))
+		}
+		commands = append(commands, fmt.Sprintf("cat %s > '%s/%s'", strings.Join(sources, " "), assembledMountPath, name))
+	}
+	return strings.Join(commands, "\n")
+}
@@ -0,0 +1,279 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package nomadcontroller
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/container/ccintf"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+)
+
+const (
+	taskName = "chaincode"
+
+	// filesDir is the directory of the task the uploaded files are rendered
+	// into before being mounted at their original location.
+	filesDir = "local/uploadedfiles"
+
+	// The uploaded files are rendered by the template stanza, delimiters which
+	// cannot appear in PEM encoded material keep their content verbatim.
+	fileLeftDelim  = "[[[fabric-nomad-no-template["
+	fileRightDelim = "]fabric-nomad-no-template]]]"
+)
+
+// The types below are the subset of the Nomad job specification used to run
+// chaincode, see https://www.nomadproject.io/api/json-jobs.html
+
+type job struct {
+	ID          string
+	Name        string
+	Type        string
+	Region      string   `json:",omitempty"`
+	Namespace   string   `json:",omitempty"`
+	Datacenters []string `json:",omitempty"`
+	Meta        map[string]string
+	TaskGroups  []*taskGroup
+}
+
+type taskGroup struct {
+	Name             string
+	Count            int
+	RestartPolicy    *restartPolicy
+	ReschedulePolicy *reschedulePolicy
+	Tasks            []*task
+}
+
+type restartPolicy struct {
+	Attempts int
+	Mode     string
+}
+
+type reschedulePolicy struct {
+	Attempts  int
+	Unlimited bool
+}
+
+type task struct {
+	Name      string
+	Driver    string
+	Config    map[string]interface{}
+	Env       map[string]string `json:",omitempty"`
+	Templates []*template       `json:",omitempty"`
+	Vault     *vault            `json:",omitempty"`
+	Resources *resources        `json:",omitempty"`
+}
+
+type template struct {
+	EmbeddedTmpl string
+	DestPath     string
+	ChangeMode   string `json:",omitempty"`
+	LeftDelim    string `json:",omitempty"`
+	RightDelim   string `json:",omitempty"`
+	Envvars      bool   `json:",omitempty"`
+}
+
+type vault struct {
+	Policies   []string
+	ChangeMode string
+}
+
+type resources struct {
+	CPU      int `json:",omitempty"`
+	MemoryMB int `json:",omitempty"`
+}
+
+// newJob creates the batch job running the chaincode. Nomad neither restarts
+// nor reschedules the chaincode, the peer relaunches it when required.
+//
+// The uploaded files include the TLS private key of the chaincode. Unless
+// vm.nomad.variables.enabled is set, they are embedded in the job and can be
+// read by any ACL token allowed to read the job.
+func (api *NomadAPI) newJob(ccid ccintf.CCID, args []string, env []string, filesToUpload map[string][]byte) (*job, error) {
+	jobID := api.GetJobName(ccid)
+
+	config := map[string]interface{}{
+		"image": api.GetChainCodeImageName(ccid),
+	}
+	if len(args) > 0 {
+		config["command"] = args[0]
+		config["args"] = args[1:]
+	}
+	if mode := viper.GetString("vm.nomad.networkMode"); mode != "" {
+		config["network_mode"] = mode
+	}
+
+	t := &task{
+		Name:      taskName,
+		Driver:    "docker",
+		Config:    config,
+		Env:       map[string]string{},
+		Resources: getResources(),
+	}
+	for _, v := range env {
+		// Use splitN(.., .., 2) here to handle base64 encoded strings coming in thru env.
+		ss := strings.SplitN(v, "=", 2)
+		if len(ss) == 2 {
+			t.Env[ss[0]] = ss[1]
+		}
+	}
+
+	var varPath string
+	if variablesEnabled() {
+		varPath = variablePath(jobID)
+	}
+	root, templates, err := uploadedFileTemplates(filesToUpload, varPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(templates) > 0 {
+		config["volumes"] = []string{filesDir + ":" + root}
+		t.Templates = append(t.Templates, templates...)
+	}
+
+	if policies := viper.GetStringSlice("vm.nomad.vault.policies"); len(policies) > 0 {
+		t.Vault = &vault{Policies: policies, ChangeMode: "restart"}
+	}
+	if secrets := viper.GetString("vm.nomad.vault.env"); secrets != "" {
+		t.Templates = append(t.Templates, &template{
+			EmbeddedTmpl: secrets,
+			DestPath:     "secrets/vault.env",
+			ChangeMode:   "restart",
+			Envvars:      true,
+		})
+	}
+
+	return &job{
+		ID:          jobID,
+		Name:        jobID,
+		Type:        "batch",
+		Region:      api.Region,
+		Namespace:   api.Namespace,
+		Datacenters: getDatacenters(),
+		Meta: map[string]string{
+			"service":    "peer-chaincode",
+			"peer-owner": api.PeerID,
+			"ccname":     ccid.Name,
+			"ccver":      ccid.Version,
+		},
+		TaskGroups: []*taskGroup{
+			{
+				Name:             taskName,
+				Count:            1,
+				RestartPolicy:    &restartPolicy{Attempts: 0, Mode: "fail"},
+				ReschedulePolicy: &reschedulePolicy{Attempts: 0, Unlimited: false},
+				Tasks:            []*task{t},
+			},
+		},
+	}, nil
+}
+
+// uploadedFileTemplates returns the common directory of the files and the
+// templates rendering them below filesDir. The templates embed the content of
+// the files, or read it from the Nomad variable at varPath when it is set.
+func uploadedFileTemplates(filesToUpload map[string][]byte, varPath string) (string, []*template, error) {
+	if len(filesToUpload) == 0 {
+		return "", nil, nil
+	}
+
+	names := sortedNames(filesToUpload)
+	root := path.Dir(names[0])
+	for _, name := range names[1:] {
+		for root != "/" && !strings.HasPrefix(name, root+"/") {
+			root = path.Dir(root)
+		}
+	}
+	// The volume would be mounted over the root of the container.
+	if root == "/" {
+		return "", nil, errors.Errorf("uploaded files %s have no common directory", strings.Join(names, ", "))
+	}
+
+	templates := make([]*template, 0, len(names))
+	for i, name := range names {
+		t := &template{
+			DestPath:   path.Join(filesDir, strings.TrimPrefix(name, root)),
+			ChangeMode: "noop",
+		}
+		if varPath != "" {
+			t.EmbeddedTmpl = fmt.Sprintf(`{{ with nomadVar "%s" }}{{ .%s }}{{ end }}`, varPath, fileItem(i))
+		} else {
+			t.EmbeddedTmpl = string(filesToUpload[name])
+			t.LeftDelim = fileLeftDelim
+			t.RightDelim = fileRightDelim
+		}
+		templates = append(templates, t)
+	}
+	return root, templates, nil
+}
+
+// fileItems returns the variable items holding the uploaded files, named as
+// referenced by uploadedFileTemplates.
+func fileItems(filesToUpload map[string][]byte) map[string]string {
+	items := make(map[string]string, len(filesToUpload))
+	for i, name := range sortedNames(filesToUpload) {
+		items[fileItem(i)] = string(filesToUpload[name])
+	}
+	return items
+}
+
+func fileItem(i int) string {
+	return fmt.Sprintf("file%d", i)
+}
+
+func sortedNames(filesToUpload map[string][]byte) []string {
+	names := make([]string, 0, len(filesToUpload))
+	for name := range filesToUpload {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// variablesEnabled reports whether the uploaded files are stored in a Nomad
+// variable rather than in the job, as set by vm.nomad.variables.enabled.
+func variablesEnabled() bool {
+	return viper.GetBool("vm.nomad.variables.enabled")
+}
+
+// variablePath returns the path of the variable holding the uploaded files of
+// the job, which Nomad lets the tasks of the job read.
+func variablePath(jobID string) string {
+	return "nomad/jobs/" + jobID
+}
+
+// getDatacenters returns vm.nomad.datacenters, defaulting to dc1.
+func getDatacenters() []string {
+	if dcs := viper.GetStringSlice("vm.nomad.datacenters"); len(dcs) > 0 {
+		return dcs
+	}
+	return []string{"dc1"}
+}
+
+// getResources returns the task resources set by vm.nomad.resources, or nil
+// to use the Nomad defaults.
+func getResources() *resources {
+	r := &resources{
+		CPU:      viper.GetInt("vm.nomad.resources.cpu"),
+		MemoryMB: viper.GetInt("vm.nomad.resources.memoryMB"),
+	}
+	if r.CPU == 0 && r.MemoryMB == 0 {
+		return nil
+	}
+	return r
+}
+
+// GetChainCodeImageName formats the chaincode image name from the same
+// chaincode.registry settings as the kubernetes controller.
+func (api *NomadAPI) GetChainCodeImageName(ccid ccintf.CCID) string {
+	ns := viper.GetString("chaincode.registry.namespace")
+	prefix := viper.GetString("chaincode.registry.prefix")
+	return fmt.Sprintf("%s/%s-%s:%s", ns, prefix, ccid.Name, ccid.Version)
+}
@@ -0,0 +1,474 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package nomadcontroller
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric/common/flogging"
+	"github.com/hyperledger/fabric/core/config"
+	"github.com/hyperledger/fabric/core/container"
+	"github.com/hyperledger/fabric/core/container/ccintf"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+)
+
+// defaultAddress is the address of the local Nomad agent, used when neither
+// vm.nomad.address nor NOMAD_ADDR is set.
+const defaultAddress = "http://127.0.0.1:4646"
+
+// defaultStartTimeout is the time Start waits for the chaincode allocation to
+// be running when vm.nomad.startTimeout is not set.
+const defaultStartTimeout = 5 * time.Minute
+
+// maxBlockingWait bounds the wait of a single blocking query.
+const maxBlockingWait = 5 * time.Minute
+
+// requestTimeout bounds a request to the Nomad API, in addition to the wait of
+// a blocking query, so an agent which stops responding does not block the peer.
+var requestTimeout = 30 * time.Second
+
+var (
+	nomadLogger = flogging.MustGetLogger("nomadcontroller")
+	jobRegExp   = regexp.MustCompile("[^a-zA-Z0-9-_.]")
+
+	// registrations holds the modify index of the latest registration of each
+	// job. Allocations created before it belong to a job registered earlier
+	// under the same ID. It is shared as a NomadAPI is created per request.
+	registrations = struct {
+		sync.Mutex
+		indexes map[string]uint64
+	}{indexes: map[string]uint64{}}
+)
+
+func setRegistrationIndex(jobID string, index uint64) {
+	registrations.Lock()
+	defer registrations.Unlock()
+	registrations.indexes[jobID] = index
+}
+
+func registrationIndex(jobID string) uint64 {
+	registrations.Lock()
+	defer registrations.Unlock()
+	return registrations.indexes[jobID]
+}
+
+// NomadAPI is a container.VM which runs chaincode as Nomad batch jobs.
+type NomadAPI struct {
+	PeerID    string
+	NetworkID string
+	Address   string
+	Token     string
+	Region    string
+	Namespace string
+
+	client *http.Client
+}
+
+// NewNomadAPI creates a NomadAPI for the Nomad agent at vm.nomad.address.
+func NewNomadAPI(peerID, networkID string) (*NomadAPI, error) {
+	client, err := newHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return &NomadAPI{
+		PeerID:    peerID,
+		NetworkID: networkID,
+		Address:   settingOrEnv("vm.nomad.address", "NOMAD_ADDR", defaultAddress),
+		Token:     settingOrEnv("vm.nomad.token", "NOMAD_TOKEN", ""),
+		Region:    viper.GetString("vm.nomad.region"),
+		Namespace: viper.GetString("vm.nomad.namespace"),
+		client:    client,
+	}, nil
+}
+
+// settingOrEnv returns the viper setting key, the environment variable env or
+// def, whichever is set first.
+func settingOrEnv(key, env, def string) string {
+	if v := viper.GetString(key); v != "" {
+		return v
+	}
+	if v := os.Getenv(env); v != "" {
+		return v
+	}
+	return def
+}
+
+// newHTTPClient creates the client for the Nomad API, using TLS when
+// vm.nomad.tls.enabled is set.
+func newHTTPClient() (*http.Client, error) {
+	if !viper.GetBool("vm.nomad.tls.enabled") {
+		return &http.Client{}, nil
+	}
+
+	tlsConfig := &tls.Config{}
+	if caFile := config.GetPath("vm.nomad.tls.ca.file"); caFile != "" {
+		caPEM, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed reading Nomad CA certificate %s", caFile)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caPEM) {
+			return nil, errors.Errorf("no certificates found in Nomad CA certificate %s", caFile)
+		}
+	}
+	if certFile := config.GetPath("vm.nomad.tls.cert.file"); certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, config.GetPath("vm.nomad.tls.key.file"))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed loading Nomad client key pair")
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}, nil
+}
+
+// Start registers the chaincode job and waits for its allocation to run.
+func (api *NomadAPI) Start(ccid ccintf.CCID,
+	args []string, env []string, filesToUpload map[string][]byte, builder container.Builder) error {
+
+	jobID := api.GetJobName(ccid)
+	nomadLogger.Infof("Starting chaincode %s...", jobID)
+
+	timeout := getStartTimeout()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// Replace any job left over from a previous launch.
+	if err := api.deregister(ctx, jobID, true); err != nil {
+		nomadLogger.Warningf("start - failed removing existing chaincode job %s: %s", jobID, err)
+	}
+
+	j, err := api.newJob(ccid, args, env, filesToUpload)
+	if err != nil {
+		nomadLogger.Errorf("start - cannot create chaincode job %s: %s", jobID, err)
+		return err
+	}
+
+	if variablesEnabled() && len(filesToUpload) > 0 {
+		if err := api.putVariable(ctx, variablePath(jobID), fileItems(filesToUpload)); err != nil {
+			nomadLogger.Errorf("start - cannot store uploaded files of chaincode job %s: %s", jobID, err)
+			return err
+		}
+	}
+
+	req := struct{ Job *job }{Job: j}
+	var resp struct {
+		EvalID         string
+		JobModifyIndex uint64
+	}
+	if _, err := api.do(ctx, http.MethodPut, "/v1/jobs", nil, req, &resp); err != nil {
+		nomadLogger.Errorf("start - cannot register chaincode job %s: %s", jobID, err)
+		return err
+	}
+	nomadLogger.Debugf("start - registered chaincode job %s, evaluation %s", jobID, resp.EvalID)
+	setRegistrationIndex(jobID, resp.JobModifyIndex)
+
+	if err := api.waitForRunning(ctx, jobID, resp.JobModifyIndex); err != nil {
+		if ctx.Err() != nil {
+			err = errors.Errorf("chaincode job %s did not start within %s", jobID, timeout)
+		}
+		nomadLogger.Errorf("start - chaincode job %s did not start: %s", jobID, err)
+		return err
+	}
+
+	nomadLogger.Infof("Chaincode %s started successfully.", jobID)
+	return nil
+}
+
+// getStartTimeout returns the configured vm.nomad.startTimeout or the default when not set.
+func getStartTimeout() time.Duration {
+	timeout := viper.GetDuration("vm.nomad.startTimeout")
+	if timeout <= 0 {
+		return defaultStartTimeout
+	}
+	return timeout
+}
+
+// waitForRunning blocks until the latest allocation of the job registered at
+// minIndex is running, returning an error if it fails or ctx is done first.
+func (api *NomadAPI) waitForRunning(ctx context.Context, jobID string, minIndex uint64) error {
+	var index uint64
+	for {
+		wait := maxBlockingWait
+		if deadline, ok := ctx.Deadline(); ok {
+			wait = time.Until(deadline)
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		allocs, next, err := api.allocations(ctx, jobID, index, wait)
+		if err != nil {
+			return err
+		}
+		if alloc := latestAllocation(allocs, minIndex); alloc != nil {
+			switch alloc.ClientStatus {
+			case "running":
+				return nil
+			case "complete", "failed", "lost":
+				return errors.Errorf("chaincode job %s allocation %s is %s: %s", jobID, alloc.ID, alloc.ClientStatus, alloc.lastEvent())
+			}
+		}
+		index = next
+	}
+}
+
+// Stop deregisters the chaincode job, purging it unless dontremove is set.
+func (api *NomadAPI) Stop(ccid ccintf.CCID, timeout uint, dontkill bool, dontremove bool) error {
+	jobID := api.GetJobName(ccid)
+	nomadLogger.Infof("Stop chaincode %s requested. [kill=%t, remove=%t]", jobID, !dontkill, !dontremove)
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	return api.deregister(ctx, jobID, !dontremove)
+}
+
+func (api *NomadAPI) deregister(ctx context.Context, jobID string, purge bool) error {
+	query := url.Values{"purge": {strconv.FormatBool(purge)}}
+	_, err := api.do(ctx, http.MethodDelete, "/v1/job/"+url.PathEscape(jobID), query, nil, nil)
+	if err != nil && !isNotFound(err) {
+		return err
+	}
+
+	if purge && variablesEnabled() {
+		_, err := api.do(ctx, http.MethodDelete, "/v1/var/"+variablePath(jobID), nil, nil, nil)
+		if err != nil && !isNotFound(err) {
+			return errors.WithMessage(err, "failed removing uploaded files")
+		}
+	}
+	return nil
+}
+
+// putVariable stores the items in the Nomad variable at path.
+func (api *NomadAPI) putVariable(ctx context.Context, path string, items map[string]string) error {
+	req := struct {
+		Namespace string `json:",omitempty"`
+		Path      string
+		Items     map[string]string
+	}{Namespace: api.Namespace, Path: path, Items: items}
+	_, err := api.do(ctx, http.MethodPut, "/v1/var/"+path, nil, req, nil)
+	return err
+}
+
+// Wait blocks until the chaincode allocation stops and returns the exit code
+// of the chaincode task.
+func (api *NomadAPI) Wait(ccid ccintf.CCID) (int, error) {
+	jobID := api.GetJobName(ccid)
+	nomadLogger.Infof("Waiting for %s to exit...", jobID)
+
+	var index uint64
+	for {
+		allocs, next, err := api.allocations(context.Background(), jobID, index, maxBlockingWait)
+		if isNotFound(err) {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		alloc := latestAllocation(allocs, registrationIndex(jobID))
+		if alloc == nil {
+			// The job was purged.
+			return 0, nil
+		}
+		if alloc.terminal() {
+			nomadLogger.Infof("Chaincode %s exited: %s", jobID, alloc.lastEvent())
+			return alloc.exitCode(), nil
+		}
+		index = next
+	}
+}
+
+// HealthCheck checks that the Nomad cluster has a leader.
+func (api *NomadAPI) HealthCheck(ctx context.Context) error {
+	_, err := api.do(ctx, http.MethodGet, "/v1/status/leader", nil, nil, nil)
+	return errors.WithMessage(err, "failed to reach Nomad")
+}
+
+// GetJobName composes a name for a chaincode job based on available metadata
+func (api *NomadAPI) GetJobName(ccid ccintf.CCID) string {
+	name := ccid.GetName()
+	if api.PeerID != "" {
+		name = fmt.Sprintf("cc-%s-%s", api.PeerID, name)
+	} else {
+		name = fmt.Sprintf("cc-%s", name)
+	}
+	// replace any invalid characters with "-"
+	return jobRegExp.ReplaceAllString(name, "-")
+}
+
+type allocation struct {
+	ID           string
+	ClientStatus string
+	CreateIndex  uint64
+	TaskStates   map[string]*taskState
+}
+
+type taskState struct {
+	State  string
+	Failed bool
+	Events []*taskEvent
+}
+
+type taskEvent struct {
+	Type           string
+	ExitCode       int
+	DisplayMessage string
+}
+
+func (a *allocation) terminal() bool {
+	switch a.ClientStatus {
+	case "complete", "failed", "lost":
+		return true
+	}
+	return false
+}
+
+// exitCode returns the exit code of the last termination of the chaincode task.
+func (a *allocation) exitCode() int {
+	ts := a.TaskStates[taskName]
+	if ts == nil {
+		return 0
+	}
+	for i := len(ts.Events) - 1; i >= 0; i-- {
+		if ts.Events[i].Type == "Terminated" {
+			return ts.Events[i].ExitCode
+		}
+	}
+	return 0
+}
+
+// lastEvent describes the last event of the chaincode task.
+func (a *allocation) lastEvent() string {
+	ts := a.TaskStates[taskName]
+	if ts == nil || len(ts.Events) == 0 {
+		return a.ClientStatus
+	}
+	e := ts.Events[len(ts.Events)-1]
+	if e.DisplayMessage == "" {
+		return e.Type
+	}
+	return fmt.Sprintf("%s: %s", e.Type, e.DisplayMessage)
+}
+
+// latestAllocation returns the most recently created allocation not created
+// before minIndex, or nil.
+func latestAllocation(allocs []*allocation, minIndex uint64) *allocation {
+	var latest *allocation
+	for _, a := range allocs {
+		if a.CreateIndex < minIndex {
+			continue
+		}
+		if latest == nil || a.CreateIndex > latest.CreateIndex {
+			latest = a
+		}
+	}
+	return latest
+}
+
+// allocations lists the allocations of the job with a blocking query, returning
+// once the allocations change after index or wait has passed.
+func (api *NomadAPI) allocations(ctx context.Context, jobID string, index uint64, wait time.Duration) ([]*allocation, uint64, error) {
+	if wait > maxBlockingWait {
+		wait = maxBlockingWait
+	}
+	// Nomad adds up to wait/16 of jitter to blocking queries.
+	ctx, cancel := context.WithTimeout(ctx, wait+wait/16+requestTimeout)
+	defer cancel()
+
+	query := url.Values{}
+	if index > 0 {
+		query.Set("index", strconv.FormatUint(index, 10))
+		query.Set("wait", fmt.Sprintf("%dms", wait/time.Millisecond))
+	}
+
+	var allocs []*allocation
+	next, err := api.do(ctx, http.MethodGet, "/v1/job/"+url.PathEscape(jobID)+"/allocations", query, nil, &allocs)
+	return allocs, next, err
+}
+
+type statusError struct {
+	code int
+	msg  string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("nomad API returned %d: %s", e.code, e.msg)
+}
+
+func isNotFound(err error) bool {
+	se, ok := errors.Cause(err).(*statusError)
+	return ok && se.code == http.StatusNotFound
+}
+
+// do sends a request to the Nomad API, encoding in and decoding the response
+// into out when they are not nil. It returns the X-Nomad-Index of the response.
+func (api *NomadAPI) do(ctx context.Context, method, path string, query url.Values, in, out interface{}) (uint64, error) {
+	if query == nil {
+		query = url.Values{}
+	}
+	if api.Region != "" {
+		query.Set("region", api.Region)
+	}
+	if api.Namespace != "" {
+		query.Set("namespace", api.Namespace)
+	}
+
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return 0, errors.Wrap(err, "failed encoding Nomad request")
+		}
+	}
+
+	req, err := http.NewRequest(method, api.Address+path+"?"+query.Encode(), &body)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed creating Nomad request")
+	}
+	req = req.WithContext(ctx)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if api.Token != "" {
+		req.Header.Set("X-Nomad-Token", api.Token)
+	}
+
+	resp, err := api.client.Do(req)
+	if err != nil {
+		return 0, errors.Wrapf(err, "%s %s failed", method, path)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return 0, errors.WithStack(&statusError{code: resp.StatusCode, msg: string(bytes.TrimSpace(msg))})
+	}
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return 0, errors.Wrapf(err, "failed decoding response of %s %s", method, path)
+		}
+	}
+
+	index, _ := strconv.ParseUint(resp.Header.Get("X-Nomad-Index"), 10, 64)
+	return index, nil
+}
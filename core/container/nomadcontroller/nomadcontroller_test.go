@@ -0,0 +1,294 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package nomadcontroller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/container/ccintf"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeNomad serves the parts of the Nomad API used by NomadAPI, returning the
+// allocation statuses in turn from successive allocation queries.
+type fakeNomad struct {
+	mutex        sync.Mutex
+	registered   *job
+	deregistered []string
+	variables    map[string]map[string]string
+	statuses     []string
+	stale        []*allocation
+	exitCode     int
+	index        int
+}
+
+func (f *fakeNomad) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.index++
+	w.Header().Set("X-Nomad-Index", strconv.Itoa(f.index))
+
+	switch {
+	case r.Method == http.MethodPut && r.URL.Path == "/v1/jobs":
+		var req struct{ Job *job }
+		json.NewDecoder(r.Body).Decode(&req)
+		f.registered = req.Job
+		json.NewEncoder(w).Encode(map[string]interface{}{"EvalID": "eval1", "JobModifyIndex": f.index})
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/v1/var/"):
+		var req struct{ Items map[string]string }
+		json.NewDecoder(r.Body).Decode(&req)
+		if f.variables == nil {
+			f.variables = map[string]map[string]string{}
+		}
+		f.variables[strings.TrimPrefix(r.URL.Path, "/v1/var/")] = req.Items
+		w.Write([]byte(`{}`))
+	case r.Method == http.MethodDelete:
+		f.deregistered = append(f.deregistered, r.URL.Path+"?"+r.URL.RawQuery)
+		http.Error(w, "job not found", http.StatusNotFound)
+	case r.URL.Path == "/v1/job/cc-peer0-mycc-1.0/allocations":
+		allocs := append([]*allocation{}, f.stale...)
+		if len(f.statuses) > 0 {
+			status := f.statuses[0]
+			if len(f.statuses) > 1 {
+				f.statuses = f.statuses[1:]
+			}
+			allocs = append(allocs, &allocation{
+				ID:           "alloc1",
+				ClientStatus: status,
+				CreateIndex:  100,
+				TaskStates: map[string]*taskState{
+					taskName: {Events: []*taskEvent{{Type: "Terminated", ExitCode: f.exitCode, DisplayMessage: "Exit Code: 2"}}},
+				},
+			})
+		}
+		json.NewEncoder(w).Encode(allocs)
+	case r.URL.Path == "/v1/status/leader":
+		w.Write([]byte(`"127.0.0.1:4647"`))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newTestAPI(t *testing.T, f *fakeNomad) (*NomadAPI, func()) {
+	server := httptest.NewServer(f)
+	viper.Set("vm.nomad.address", server.URL)
+	api, err := NewNomadAPI("peer0", "network")
+	require.NoError(t, err)
+	return api, func() {
+		server.Close()
+		viper.Set("vm.nomad.address", nil)
+	}
+}
+
+var ccid = ccintf.CCID{Name: "mycc", Version: "1.0"}
+
+func TestStartWaitStop(t *testing.T) {
+	f := &fakeNomad{statuses: []string{"pending", "running", "running", "failed"}, exitCode: 2}
+	api, cleanup := newTestAPI(t, f)
+	defer cleanup()
+
+	err := api.Start(ccid, []string{"chaincode", "-peer.address=peer0:7052"}, []string{"CORE_CHAINCODE_ID_NAME=mycc:1.0"}, nil, nil)
+	require.NoError(t, err)
+	require.NotNil(t, f.registered)
+	assert.Equal(t, "cc-peer0-mycc-1.0", f.registered.ID)
+	assert.Equal(t, "batch", f.registered.Type)
+	task := f.registered.TaskGroups[0].Tasks[0]
+	assert.Equal(t, "chaincode", task.Config["command"])
+	assert.Equal(t, []interface{}{"-peer.address=peer0:7052"}, task.Config["args"])
+	assert.Equal(t, "mycc:1.0", task.Env["CORE_CHAINCODE_ID_NAME"])
+
+	code, err := api.Wait(ccid)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, code)
+
+	err = api.Stop(ccid, 10, false, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"/v1/job/cc-peer0-mycc-1.0?purge=true",
+		"/v1/job/cc-peer0-mycc-1.0?purge=true",
+	}, f.deregistered)
+}
+
+func TestStartIgnoresPreviousAllocations(t *testing.T) {
+	// The allocation of a job registered earlier under the same ID is still running.
+	stale := &allocation{ID: "alloc0", ClientStatus: "running", CreateIndex: 1}
+	f := &fakeNomad{statuses: []string{"pending", "failed"}, stale: []*allocation{stale}, index: 10}
+	api, cleanup := newTestAPI(t, f)
+	defer cleanup()
+
+	err := api.Start(ccid, nil, nil, nil, nil)
+	assert.EqualError(t, err, "chaincode job cc-peer0-mycc-1.0 allocation alloc1 is failed: Terminated: Exit Code: 2")
+	assert.Equal(t, uint64(12), registrationIndex("cc-peer0-mycc-1.0"))
+
+	assert.Equal(t, stale, latestAllocation([]*allocation{stale}, 0))
+	assert.Nil(t, latestAllocation([]*allocation{stale}, 12))
+}
+
+func TestStartVariables(t *testing.T) {
+	defer viper.Set("vm.nomad.variables.enabled", nil)
+	viper.Set("vm.nomad.variables.enabled", true)
+
+	f := &fakeNomad{statuses: []string{"running"}}
+	api, cleanup := newTestAPI(t, f)
+	defer cleanup()
+
+	files := map[string][]byte{
+		"/etc/hyperledger/fabric/client.crt": []byte("cert"),
+		"/etc/hyperledger/fabric/client.key": []byte("key"),
+	}
+	require.NoError(t, api.Start(ccid, nil, nil, files, nil))
+	assert.Equal(t, map[string]map[string]string{
+		"nomad/jobs/cc-peer0-mycc-1.0": {"file0": "cert", "file1": "key"},
+	}, f.variables)
+
+	templates := f.registered.TaskGroups[0].Tasks[0].Templates
+	require.Len(t, templates, 2)
+	assert.Equal(t, `{{ with nomadVar "nomad/jobs/cc-peer0-mycc-1.0" }}{{ .file1 }}{{ end }}`, templates[1].EmbeddedTmpl)
+	assert.Equal(t, "local/uploadedfiles/client.key", templates[1].DestPath)
+	assert.Empty(t, templates[1].LeftDelim)
+
+	require.NoError(t, api.Stop(ccid, 10, false, false))
+	assert.Contains(t, f.deregistered, "/v1/var/nomad/jobs/cc-peer0-mycc-1.0?")
+}
+
+func TestStartFailed(t *testing.T) {
+	f := &fakeNomad{statuses: []string{"failed"}}
+	api, cleanup := newTestAPI(t, f)
+	defer cleanup()
+
+	err := api.Start(ccid, nil, nil, nil, nil)
+	assert.EqualError(t, err, "chaincode job cc-peer0-mycc-1.0 allocation alloc1 is failed: Terminated: Exit Code: 2")
+}
+
+func TestStartTimeout(t *testing.T) {
+	defer viper.Set("vm.nomad.startTimeout", nil)
+	viper.Set("vm.nomad.startTimeout", 50*time.Millisecond)
+
+	f := &fakeNomad{statuses: []string{"pending"}}
+	api, cleanup := newTestAPI(t, f)
+	defer cleanup()
+
+	err := api.Start(ccid, nil, nil, nil, nil)
+	assert.EqualError(t, err, "chaincode job cc-peer0-mycc-1.0 did not start within 50ms")
+}
+
+func TestUnresponsiveAgent(t *testing.T) {
+	defer func(timeout time.Duration) { requestTimeout = timeout }(requestTimeout)
+	requestTimeout = 50 * time.Millisecond
+	defer viper.Set("vm.nomad.startTimeout", nil)
+	viper.Set("vm.nomad.startTimeout", 50*time.Millisecond)
+
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-release }))
+	defer server.Close()
+	defer close(release)
+	defer viper.Set("vm.nomad.address", nil)
+	viper.Set("vm.nomad.address", server.URL)
+	api, err := NewNomadAPI("peer0", "network")
+	require.NoError(t, err)
+
+	start := time.Now()
+	err = api.Start(ccid, nil, nil, nil, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "context deadline exceeded")
+
+	err = api.Stop(ccid, 10, false, false)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "context deadline exceeded")
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+func TestWaitPurged(t *testing.T) {
+	api, cleanup := newTestAPI(t, &fakeNomad{})
+	defer cleanup()
+
+	code, err := api.Wait(ccid)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, code)
+}
+
+func TestHealthCheck(t *testing.T) {
+	api, cleanup := newTestAPI(t, &fakeNomad{})
+	assert.NoError(t, api.HealthCheck(context.Background()))
+
+	cleanup()
+	assert.Error(t, api.HealthCheck(context.Background()))
+}
+
+func TestNewJob(t *testing.T) {
+	defer viper.Reset()
+	viper.Set("chaincode.registry.namespace", "registry")
+	viper.Set("chaincode.registry.prefix", "fabric")
+	viper.Set("vm.nomad.datacenters", []string{"east"})
+	viper.Set("vm.nomad.resources.memoryMB", 512)
+	viper.Set("vm.nomad.vault.policies", []string{"chaincode"})
+	viper.Set("vm.nomad.vault.env", `{{ with secret "secret/cc" }}KEY={{ .Data.key }}{{ end }}`)
+
+	api := &NomadAPI{PeerID: "peer0", Namespace: "fabric"}
+	files := map[string][]byte{
+		"/etc/hyperledger/fabric/client.crt": []byte("cert"),
+		"/etc/hyperledger/fabric/peer.crt":   []byte("peer"),
+	}
+	j, err := api.newJob(ccid, nil, nil, files)
+	require.NoError(t, err)
+
+	assert.Equal(t, "fabric", j.Namespace)
+	assert.Equal(t, []string{"east"}, j.Datacenters)
+	task := j.TaskGroups[0].Tasks[0]
+	assert.Equal(t, "registry/fabric-mycc:1.0", task.Config["image"])
+	assert.Equal(t, []string{"local/uploadedfiles:/etc/hyperledger/fabric"}, task.Config["volumes"])
+	assert.Equal(t, &resources{MemoryMB: 512}, task.Resources)
+	assert.Equal(t, &vault{Policies: []string{"chaincode"}, ChangeMode: "restart"}, task.Vault)
+
+	require.Len(t, task.Templates, 3)
+	assert.Equal(t, "local/uploadedfiles/client.crt", task.Templates[0].DestPath)
+	assert.Equal(t, "cert", task.Templates[0].EmbeddedTmpl)
+	assert.Equal(t, fileLeftDelim, task.Templates[0].LeftDelim)
+	assert.Equal(t, "local/uploadedfiles/peer.crt", task.Templates[1].DestPath)
+	assert.True(t, task.Templates[2].Envvars)
+	assert.Equal(t, "secrets/vault.env", task.Templates[2].DestPath)
+}
+
+func TestUploadedFileTemplatesRoot(t *testing.T) {
+	root, templates, err := uploadedFileTemplates(map[string][]byte{
+		"/etc/hyperledger/fabric/tls/client.crt": nil,
+		"/etc/hyperledger/fabric/peer.crt":       nil,
+	}, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "/etc/hyperledger/fabric", root)
+	assert.Equal(t, "local/uploadedfiles/peer.crt", templates[0].DestPath)
+	assert.Equal(t, "local/uploadedfiles/tls/client.crt", templates[1].DestPath)
+
+	root, templates, err = uploadedFileTemplates(nil, "")
+	assert.NoError(t, err)
+	assert.Empty(t, root)
+	assert.Empty(t, templates)
+
+	_, _, err = uploadedFileTemplates(map[string][]byte{
+		"/etc/client.crt": nil,
+		"/tmp/client.key": nil,
+	}, "")
+	assert.EqualError(t, err, "uploaded files /etc/client.crt, /tmp/client.key have no common directory")
+}
+
+func TestGetJobName(t *testing.T) {
+	api := &NomadAPI{}
+	assert.Equal(t, "cc-mycc-1.0", api.GetJobName(ccid))
+	api.PeerID = "peer 0"
+	assert.Equal(t, "cc-peer-0-mycc-1.0", api.GetJobName(ccid))
+}
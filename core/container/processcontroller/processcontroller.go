@@ -0,0 +1,307 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package processcontroller
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hyperledger/fabric/common/flogging"
+	"github.com/hyperledger/fabric/core/config"
+	"github.com/hyperledger/fabric/core/container"
+	"github.com/hyperledger/fabric/core/container/ccintf"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+)
+
+var processLogger = flogging.MustGetLogger("processcontroller")
+
+// inheritedEnv lists the environment variables of the peer passed on to the
+// chaincode process, which systemd-run --user needs to reach the user manager.
+var inheritedEnv = []string{
+	"PATH",
+	"HOME",
+	"USER",
+	"LANG",
+	"TMPDIR",
+	"DBUS_SESSION_BUS_ADDRESS",
+	"XDG_RUNTIME_DIR",
+}
+
+// process is a running chaincode process.
+type process struct {
+	cmd      *exec.Cmd
+	done     chan struct{}
+	exitCode int
+}
+
+// ProcessVM is a container.VM which runs pre-built chaincode binaries as
+// processes of the local host.
+type ProcessVM struct {
+	PeerID string
+
+	mutex     sync.Mutex
+	processes map[string]*process
+}
+
+// NewProcessVM creates a ProcessVM. The processes it starts are tracked by
+// the ProcessVM, so the same instance must be used to Wait for and Stop them.
+func NewProcessVM(peerID string) *ProcessVM {
+	return &ProcessVM{
+		PeerID:    peerID,
+		processes: map[string]*process{},
+	}
+}
+
+// Start writes the uploaded files to the working directory of the chaincode
+// and executes its binary from vm.process.binaryDir.
+func (vm *ProcessVM) Start(ccid ccintf.CCID, args []string, env []string, filesToUpload map[string][]byte, builder container.Builder) error {
+	name := ccid.GetName()
+	processLogger.Infof("Starting chaincode %s...", name)
+
+	// Clean up any process left over from a previous launch.
+	vm.Stop(ccid, 0, false, false)
+
+	binary, err := binaryPath(ccid)
+	if err != nil {
+		return err
+	}
+
+	workDir := vm.workDir(ccid)
+	env, err = writeFiles(workDir, env, filesToUpload)
+	if err != nil {
+		return err
+	}
+
+	logFile, err := os.OpenFile(filepath.Join(workDir, "chaincode.log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return errors.Wrapf(err, "failed creating log file for chaincode %s", name)
+	}
+	defer logFile.Close()
+
+	// The first argument names the executable inside a chaincode container.
+	if len(args) > 0 {
+		args = args[1:]
+	}
+	command := append(launcher(vm.unitName(ccid)), binary)
+	cmd := exec.Command(command[0], append(command[1:], args...)...)
+	cmd.Dir = workDir
+	cmd.Env = append(peerEnv(), env...)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+
+	if err := cmd.Start(); err != nil {
+		processLogger.Errorf("start - cannot execute chaincode %s: %s", name, err)
+		return errors.Wrapf(err, "failed executing chaincode %s", name)
+	}
+
+	p := &process{cmd: cmd, done: make(chan struct{})}
+	go func() {
+		err := cmd.Wait()
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			p.exitCode = exitCode(exitErr)
+		} else if err != nil {
+			p.exitCode = -1
+		}
+		close(p.done)
+	}()
+
+	vm.mutex.Lock()
+	vm.processes[name] = p
+	vm.mutex.Unlock()
+
+	processLogger.Infof("Chaincode %s started as process %d.", name, cmd.Process.Pid)
+	return nil
+}
+
+// binaryPath returns the executable of the chaincode, named after the
+// chaincode name and version or, failing that, the chaincode name alone.
+func binaryPath(ccid ccintf.CCID) (string, error) {
+	dir := config.GetPath("vm.process.binaryDir")
+	if dir == "" {
+		return "", errors.New("vm.process.binaryDir is not set")
+	}
+
+	names := []string{ccid.GetName(), ccid.Name}
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path, nil
+		}
+	}
+	return "", errors.Errorf("no chaincode binary %s found in %s", strings.Join(names, " or "), dir)
+}
+
+// workDir returns the working directory of the chaincode below
+// vm.process.workDir, defaulting to the system temporary directory.
+func (vm *ProcessVM) workDir(ccid ccintf.CCID) string {
+	dir := config.GetPath("vm.process.workDir")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "fabric-chaincode")
+	}
+	return filepath.Join(dir, vm.unitName(ccid))
+}
+
+// unitName composes a name for the chaincode process based on available metadata
+func (vm *ProcessVM) unitName(ccid ccintf.CCID) string {
+	if vm.PeerID != "" {
+		return fmt.Sprintf("cc-%s-%s", vm.PeerID, ccid.GetName())
+	}
+	return fmt.Sprintf("cc-%s", ccid.GetName())
+}
+
+// writeFiles writes the uploaded files below dir and points the environment
+// variables referencing them to their new location, as chaincodes sharing the
+// host cannot share the absolute paths used inside containers.
+func writeFiles(dir string, env []string, filesToUpload map[string][]byte) ([]string, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrapf(err, "failed creating chaincode directory %s", dir)
+	}
+
+	paths := map[string]string{}
+	for name, content := range filesToUpload {
+		path := filepath.Join(dir, "files", filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return nil, errors.Wrapf(err, "failed creating directory for %s", name)
+		}
+		if err := ioutil.WriteFile(path, content, 0600); err != nil {
+			return nil, errors.Wrapf(err, "failed writing %s", name)
+		}
+		paths[name] = path
+	}
+
+	result := make([]string, 0, len(env))
+	for _, v := range env {
+		ss := strings.SplitN(v, "=", 2)
+		if len(ss) == 2 {
+			if path, ok := paths[ss[1]]; ok {
+				v = ss[0] + "=" + path
+			}
+		}
+		result = append(result, v)
+	}
+	return result, nil
+}
+
+// peerEnv returns the inheritedEnv variables set in the environment of the peer.
+func peerEnv() []string {
+	var env []string
+	for _, name := range inheritedEnv {
+		if v, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+v)
+		}
+	}
+	return env
+}
+
+// launcher returns the command wrapping the chaincode binary. With
+// vm.process.systemd.enabled, the chaincode runs in a transient systemd scope
+// limited by vm.process.systemd.properties, e.g. MemoryMax=512M or
+// CPUQuota=50%. Otherwise the binary is executed directly.
+func launcher(unit string) []string {
+	if !viper.GetBool("vm.process.systemd.enabled") {
+		return nil
+	}
+
+	command := []string{"systemd-run", "--scope", "--quiet", "--unit=" + unit}
+	if viper.GetBool("vm.process.systemd.user") {
+		command = append(command, "--user")
+	}
+	for _, property := range viper.GetStringSlice("vm.process.systemd.properties") {
+		command = append(command, "--property="+property)
+	}
+	return append(command, "--")
+}
+
+// Stop terminates the chaincode process, killing it if it has not exited after
+// timeout seconds unless dontkill is set, and removes its working directory
+// unless dontremove is set. A process still running is not removed.
+func (vm *ProcessVM) Stop(ccid ccintf.CCID, timeout uint, dontkill bool, dontremove bool) error {
+	name := ccid.GetName()
+	processLogger.Debugf("Stop chaincode %s requested. [kill=%t, remove=%t]", name, !dontkill, !dontremove)
+
+	vm.mutex.Lock()
+	p := vm.processes[name]
+	vm.mutex.Unlock()
+
+	if p != nil {
+		if err := p.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+			processLogger.Debugf("stop - cannot terminate chaincode %s: %s", name, err)
+		}
+		select {
+		case <-p.done:
+		case <-time.After(time.Duration(timeout) * time.Second):
+			if dontkill {
+				processLogger.Warningf("stop - chaincode %s did not exit within %d seconds and is left running", name, timeout)
+				return nil
+			}
+			if err := p.cmd.Process.Kill(); err != nil {
+				processLogger.Errorf("stop - cannot kill chaincode %s: %s", name, err)
+			}
+			<-p.done
+		}
+	}
+
+	if !dontremove {
+		vm.mutex.Lock()
+		if vm.processes[name] == p {
+			delete(vm.processes, name)
+		}
+		vm.mutex.Unlock()
+
+		if err := os.RemoveAll(vm.workDir(ccid)); err != nil {
+			return errors.Wrapf(err, "failed removing working directory of chaincode %s", name)
+		}
+	}
+	return nil
+}
+
+// Wait blocks until the chaincode process exits and returns its exit code.
+func (vm *ProcessVM) Wait(ccid ccintf.CCID) (int, error) {
+	name := ccid.GetName()
+
+	vm.mutex.Lock()
+	p := vm.processes[name]
+	vm.mutex.Unlock()
+
+	if p == nil {
+		return 0, errors.Errorf("chaincode process %s not found", name)
+	}
+
+	<-p.done
+	processLogger.Infof("Chaincode %s exited with code %d.", name, p.exitCode)
+	return p.exitCode, nil
+}
+
+// HealthCheck checks that the chaincode binary directory is accessible.
+func (vm *ProcessVM) HealthCheck(ctx context.Context) error {
+	dir := config.GetPath("vm.process.binaryDir")
+	if dir == "" {
+		return errors.New("vm.process.binaryDir is not set")
+	}
+	if _, err := os.Stat(dir); err != nil {
+		return errors.Wrap(err, "chaincode binary directory unavailable")
+	}
+	return nil
+}
+
+// exitCode returns the exit status of the process, or -1 when it was
+// terminated by a signal.
+func exitCode(err *exec.ExitError) int {
+	if status, ok := err.Sys().(syscall.WaitStatus); ok {
+		return status.ExitStatus()
+	}
+	return -1
+}
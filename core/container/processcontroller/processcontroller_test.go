@@ -0,0 +1,195 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package processcontroller
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/container/ccintf"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setup creates the binary and working directories and writes script as the
+// binary of the chaincode mycc-1.0.
+func setup(t *testing.T, script string) (string, func()) {
+	tempDir, err := ioutil.TempDir("", "processcontroller")
+	require.NoError(t, err)
+
+	binaryDir := filepath.Join(tempDir, "bin")
+	require.NoError(t, os.Mkdir(binaryDir, 0700))
+	err = ioutil.WriteFile(filepath.Join(binaryDir, "mycc-1.0"), []byte("#!/bin/sh\n"+script+"\n"), 0700)
+	require.NoError(t, err)
+
+	viper.Set("vm.process.binaryDir", binaryDir)
+	viper.Set("vm.process.workDir", filepath.Join(tempDir, "work"))
+	return tempDir, func() {
+		viper.Set("vm.process.binaryDir", nil)
+		viper.Set("vm.process.workDir", nil)
+		os.RemoveAll(tempDir)
+	}
+}
+
+var ccid = ccintf.CCID{Name: "mycc", Version: "1.0"}
+
+func TestStartWait(t *testing.T) {
+	tempDir, cleanup := setup(t, `cat "$CORE_TLS_CLIENT_CERT_PATH" > out; echo "$@" >> out; exit 3`)
+	defer cleanup()
+
+	vm := NewProcessVM("peer0")
+	err := vm.Start(ccid,
+		[]string{"chaincode", "-peer.address=peer0:7052"},
+		[]string{"CORE_TLS_CLIENT_CERT_PATH=/etc/hyperledger/fabric/client.crt"},
+		map[string][]byte{"/etc/hyperledger/fabric/client.crt": []byte("cert\n")},
+		nil,
+	)
+	require.NoError(t, err)
+
+	code, err := vm.Wait(ccid)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, code)
+
+	workDir := filepath.Join(tempDir, "work", "cc-peer0-mycc-1.0")
+	out, err := ioutil.ReadFile(filepath.Join(workDir, "out"))
+	require.NoError(t, err)
+	assert.Equal(t, "cert\n-peer.address=peer0:7052\n", string(out))
+
+	assert.NoError(t, vm.Stop(ccid, 0, false, false))
+	_, err = os.Stat(workDir)
+	assert.True(t, os.IsNotExist(err))
+
+	_, err = vm.Wait(ccid)
+	assert.EqualError(t, err, "chaincode process mycc-1.0 not found")
+}
+
+func TestStop(t *testing.T) {
+	_, cleanup := setup(t, `trap "" TERM; while true; do sleep 0.1; done`)
+	defer cleanup()
+
+	vm := NewProcessVM("")
+	require.NoError(t, vm.Start(ccid, nil, nil, nil, nil))
+
+	// Give the shell time to install its trap.
+	time.Sleep(200 * time.Millisecond)
+
+	exited := make(chan int, 1)
+	go func() {
+		code, _ := vm.Wait(ccid)
+		exited <- code
+	}()
+
+	assert.NoError(t, vm.Stop(ccid, 1, false, true))
+	select {
+	case code := <-exited:
+		assert.Equal(t, -1, code)
+	case <-time.After(5 * time.Second):
+		t.Fatal("chaincode process was not killed")
+	}
+}
+
+func TestStopDontKill(t *testing.T) {
+	tempDir, cleanup := setup(t, `trap "" TERM; while true; do sleep 0.1; done`)
+	defer cleanup()
+
+	vm := NewProcessVM("peer0")
+	require.NoError(t, vm.Start(ccid, nil, nil, nil, nil))
+	time.Sleep(200 * time.Millisecond)
+
+	// The process outlives the timeout, so it is neither killed nor removed.
+	assert.NoError(t, vm.Stop(ccid, 0, true, false))
+	workDir := filepath.Join(tempDir, "work", "cc-peer0-mycc-1.0")
+	_, err := os.Stat(workDir)
+	assert.NoError(t, err)
+
+	exited := make(chan int, 1)
+	go func() {
+		code, _ := vm.Wait(ccid)
+		exited <- code
+	}()
+	assert.NoError(t, vm.Stop(ccid, 0, false, false))
+	select {
+	case code := <-exited:
+		assert.Equal(t, -1, code)
+	case <-time.After(5 * time.Second):
+		t.Fatal("chaincode process was not killed")
+	}
+	_, err = os.Stat(workDir)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestStartInheritsPeerEnv(t *testing.T) {
+	tempDir, cleanup := setup(t, `echo "$XDG_RUNTIME_DIR $CORE_CHAINCODE_ID_NAME $SECRET" > out`)
+	defer cleanup()
+
+	defer os.Unsetenv("XDG_RUNTIME_DIR")
+	defer os.Unsetenv("SECRET")
+	os.Setenv("XDG_RUNTIME_DIR", "/run/user/1000")
+	os.Setenv("SECRET", "peer only")
+
+	vm := NewProcessVM("peer0")
+	require.NoError(t, vm.Start(ccid, nil, []string{"CORE_CHAINCODE_ID_NAME=mycc:1.0"}, nil, nil))
+	_, err := vm.Wait(ccid)
+	require.NoError(t, err)
+
+	out, err := ioutil.ReadFile(filepath.Join(tempDir, "work", "cc-peer0-mycc-1.0", "out"))
+	require.NoError(t, err)
+	assert.Equal(t, "/run/user/1000 mycc:1.0 \n", string(out))
+}
+
+func TestStartMissingBinary(t *testing.T) {
+	_, cleanup := setup(t, "exit 0")
+	defer cleanup()
+
+	vm := NewProcessVM("peer0")
+	err := vm.Start(ccintf.CCID{Name: "other", Version: "2.0"}, nil, nil, nil, nil)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "no chaincode binary other-2.0 or other found in")
+
+	viper.Set("vm.process.binaryDir", nil)
+	err = vm.Start(ccid, nil, nil, nil, nil)
+	assert.EqualError(t, err, "vm.process.binaryDir is not set")
+}
+
+func TestBinaryPathFallback(t *testing.T) {
+	tempDir, cleanup := setup(t, "exit 0")
+	defer cleanup()
+
+	path, err := binaryPath(ccintf.CCID{Name: "mycc-1.0", Version: "2.0"})
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(tempDir, "bin", "mycc-1.0"), path)
+}
+
+func TestLauncher(t *testing.T) {
+	defer viper.Set("vm.process.systemd", nil)
+
+	assert.Nil(t, launcher("cc-mycc"))
+
+	viper.Set("vm.process.systemd.enabled", true)
+	viper.Set("vm.process.systemd.user", true)
+	viper.Set("vm.process.systemd.properties", []string{"MemoryMax=512M", "CPUQuota=50%"})
+	assert.Equal(t, []string{
+		"systemd-run", "--scope", "--quiet", "--unit=cc-mycc", "--user",
+		"--property=MemoryMax=512M", "--property=CPUQuota=50%", "--",
+	}, launcher("cc-mycc"))
+}
+
+func TestHealthCheck(t *testing.T) {
+	tempDir, cleanup := setup(t, "exit 0")
+	defer cleanup()
+
+	vm := NewProcessVM("peer0")
+	assert.NoError(t, vm.HealthCheck(context.Background()))
+
+	viper.Set("vm.process.binaryDir", filepath.Join(tempDir, "missing"))
+	assert.Error(t, vm.HealthCheck(context.Background()))
+}
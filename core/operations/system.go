@@ -13,6 +13,7 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	kitstatsd "github.com/go-kit/kit/metrics/statsd"
@@ -26,6 +27,7 @@ import (
 	"github.com/hyperledger/fabric/common/metrics/statsd"
 	"github.com/hyperledger/fabric/common/metrics/statsd/goruntime"
 	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/comm"
 	"github.com/hyperledger/fabric/core/middleware"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
@@ -70,6 +72,7 @@ type System struct {
 	mux             *http.ServeMux
 	addr            string
 	versionGauge    metrics.Gauge
+	certReloadStop  chan struct{}
 }
 
 func NewSystem(o Options) *System {
@@ -134,6 +137,10 @@ func (s *System) Stop() error {
 		s.sendTicker.Stop()
 		s.sendTicker = nil
 	}
+	if s.certReloadStop != nil {
+		close(s.certReloadStop)
+		s.certReloadStop = nil
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -246,11 +253,30 @@ func (s *System) listen() (net.Listener, error) {
 		return nil, err
 	}
 	if tlsConfig != nil {
+		if s.options.TLS.CertReloadInterval > 0 {
+			s.watchServerCertificate(tlsConfig)
+		}
 		listener = tls.NewListener(listener, tlsConfig)
 	}
 	return listener, nil
 }
 
+// watchServerCertificate serves the certificate from the TLS configuration
+// until the certificate and key files are updated, then serves the new one.
+func (s *System) watchServerCertificate(tlsConfig *tls.Config) {
+	var serverCert atomic.Value
+	serverCert.Store(tlsConfig.Certificates[0])
+	tlsConfig.Certificates = nil
+	tlsConfig.GetCertificate = func(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		cert := serverCert.Load().(tls.Certificate)
+		return &cert, nil
+	}
+
+	s.certReloadStop = make(chan struct{})
+	update := func(cert tls.Certificate) { serverCert.Store(cert) }
+	go comm.WatchKeyPair(s.options.TLS.CertFile, s.options.TLS.KeyFile, s.options.TLS.CertReloadInterval, update, s.certReloadStop)
+}
+
 func (s *System) Addr() string {
 	return s.addr
 }
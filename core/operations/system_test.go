@@ -137,6 +137,43 @@ var _ = Describe("System", func() {
 		})
 	})
 
+	Context("when CertReloadInterval is set", func() {
+		BeforeEach(func() {
+			options.TLS.CertReloadInterval = 10 * time.Millisecond
+			system = operations.NewSystem(options)
+		})
+
+		It("serves the updated certificate without a restart", func() {
+			err := system.Start()
+			Expect(err).NotTo(HaveOccurred())
+
+			resp, err := client.Get(fmt.Sprintf("https://%s/healthz", system.Addr()))
+			Expect(err).NotTo(HaveOccurred())
+			resp.Body.Close()
+
+			tlsDir := filepath.Dir(options.TLS.CertFile)
+			newDir, err := ioutil.TempDir("", "opssys-reload")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.RemoveAll(newDir)
+			generateCertificates(newDir)
+			for _, name := range []string{"server-cert.pem", "server-key.pem"} {
+				pem, err := ioutil.ReadFile(filepath.Join(newDir, name))
+				Expect(err).NotTo(HaveOccurred())
+				err = ioutil.WriteFile(filepath.Join(tlsDir, name), pem, 0640)
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			newClient := newHTTPClient(newDir, false)
+			Eventually(func() error {
+				resp, err := newClient.Get(fmt.Sprintf("https://%s/healthz", system.Addr()))
+				if err == nil {
+					resp.Body.Close()
+				}
+				return err
+			}).Should(Succeed())
+		})
+	})
+
 	Context("when listen fails", func() {
 		var listener net.Listener
 
@@ -10,6 +10,7 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"io/ioutil"
+	"time"
 
 	"github.com/hyperledger/fabric/core/comm"
 )
@@ -20,6 +21,9 @@ type TLS struct {
 	KeyFile            string
 	ClientCertRequired bool
 	ClientCACertFiles  []string
+	// CertReloadInterval, when positive, is how often the certificate and
+	// key files are checked for changes to be loaded without a restart.
+	CertReloadInterval time.Duration
 }
 
 func (t TLS) Config() (*tls.Config, error) {
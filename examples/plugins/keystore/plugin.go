@@ -0,0 +1,36 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+package main
+
+import (
+	"errors"
+
+	"github.com/hyperledger/fabric/bccsp"
+)
+
+type keyStore struct{}
+
+// NewKeyStore returns a new instance of the KeyStore implementation
+func NewKeyStore(config map[string]interface{}) (bccsp.KeyStore, error) {
+	return &keyStore{}, nil
+}
+
+// ReadOnly returns true if this KeyStore is read only, false otherwise.
+// If ReadOnly is true then StoreKey will fail.
+func (ks *keyStore) ReadOnly() bool {
+	return true
+}
+
+// GetKey returns a key object whose SKI is the one passed.
+func (ks *keyStore) GetKey(ski []byte) (k bccsp.Key, err error) {
+	return nil, errors.New("key not found")
+}
+
+// StoreKey stores the key k in this KeyStore.
+// If this KeyStore is read only then the method will fail.
+func (ks *keyStore) StoreKey(k bccsp.Key) (err error) {
+	return errors.New("read only key store")
+}
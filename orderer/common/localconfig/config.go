@@ -93,6 +93,7 @@ type TLS struct {
 	RootCAs            []string
 	ClientAuthRequired bool
 	ClientRootCAs      []string
+	CertReloadInterval time.Duration
 }
 
 // SASLPlain contains configuration for SASL/PLAIN authentication
@@ -174,6 +174,23 @@ func Start(cmd string, conf *localconfig.TopLevel) {
 		time.Now(),
 		time.AfterFunc)
 
+	expirationCerts := crypto.ExpirationCertificates(
+		serverConfig.SecOpts.UseTLS,
+		serverConfig.SecOpts.Certificate,
+		[][]byte{clusterClientConfig.SecOpts.Certificate},
+		sigHdr.Creator)
+	if mspConfig, err := msp.GetVerifyingMspConfig(conf.General.LocalMSPDir, conf.General.LocalMSPID, msp.ProviderTypeToString(msp.FABRIC)); err != nil {
+		expirationLogger.Warningf("Failed loading local MSP CA certificates, their expiration is not reported: %s", err)
+	} else if caCerts, err := crypto.MSPCACertificates(mspConfig); err != nil {
+		expirationLogger.Warningf("Failed loading local MSP CA certificates, their expiration is not reported: %s", err)
+	} else {
+		expirationCerts = append(expirationCerts, caCerts...)
+	}
+	if conf.General.TLS.CertReloadInterval > 0 {
+		crypto.ReloadedFrom(expirationCerts, "server_tls", conf.General.TLS.Certificate)
+	}
+	go crypto.NewExpirationReporter(metricsProvider, expirationCerts).Run(time.Hour, nil)
+
 	manager := initializeMultichannelRegistrar(clusterBootBlock, r, clusterDialer, clusterServerConfig, clusterGRPCServer, conf, signer, metricsProvider, opsSystem, lf, tlsCallback)
 	mutualTLS := serverConfig.SecOpts.UseTLS && serverConfig.SecOpts.RequireClientCert
 	expiration := conf.General.Authentication.NoExpirationChecks
@@ -604,6 +621,11 @@ func initializeGrpcServer(conf *localconfig.TopLevel, serverConfig comm.ServerCo
 		logger.Fatal("Failed to return new GRPC server:", err)
 	}
 
+	if conf.General.TLS.Enabled && conf.General.TLS.CertReloadInterval > 0 {
+		tlsConf := conf.General.TLS
+		go comm.WatchKeyPair(tlsConf.Certificate, tlsConf.PrivateKey, tlsConf.CertReloadInterval, grpcServer.SetServerCertificate, nil)
+	}
+
 	return grpcServer
 }
 
@@ -732,6 +754,7 @@ func newOperationsSystem(ops localconfig.Operations, metrics localconfig.Metrics
 			KeyFile:            ops.TLS.PrivateKey,
 			ClientCertRequired: ops.TLS.ClientAuthRequired,
 			ClientCACertFiles:  ops.TLS.ClientRootCAs,
+			CertReloadInterval: ops.TLS.CertReloadInterval,
 		},
 		Version: metadata.Version,
 	})
@@ -0,0 +1,101 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package node
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hyperledger/fabric/bccsp/factory"
+	"github.com/hyperledger/fabric/common/viperutil"
+	coreconfig "github.com/hyperledger/fabric/core/config"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+)
+
+// enforceNoKeysOnDisk returns an error if peer.noKeysOnDisk is set and either
+// the BCCSP would keep private keys on the file system or private keys are
+// found in the MSP or BCCSP keystore directories.
+func enforceNoKeysOnDisk() error {
+	if !viper.GetBool("peer.noKeysOnDisk") {
+		return nil
+	}
+
+	var bccspConfig *factory.FactoryOpts
+	if err := viperutil.EnhancedExactUnmarshalKey("peer.BCCSP", &bccspConfig); err != nil {
+		return errors.WithMessage(err, "could not parse YAML config")
+	}
+
+	keystoreDirs := []string{filepath.Join(coreconfig.GetPath("peer.mspConfigPath"), "keystore")}
+	if dir := coreconfig.GetPath("peer.BCCSP.SW.FileKeyStore.KeyStore"); dir != "" {
+		keystoreDirs = append(keystoreDirs, dir)
+	}
+
+	return checkNoKeysOnDisk(bccspConfig, keystoreDirs...)
+}
+
+// checkNoKeysOnDisk returns an error if the BCCSP configuration stores private
+// keys in the file keystore or if any of the keystore directories contains a
+// private key.
+func checkNoKeysOnDisk(bccspConfig *factory.FactoryOpts, keystoreDirs ...string) error {
+	swProvider := bccspConfig == nil || bccspConfig.ProviderName == "" || bccspConfig.ProviderName == factory.SoftwareBasedFactoryName
+	if swProvider && (bccspConfig == nil || bccspConfig.SwOpts == nil || bccspConfig.SwOpts.PluginKeystore == nil) {
+		return errors.New("peer.noKeysOnDisk requires the SW BCCSP to use a plugin key store")
+	}
+
+	for _, dir := range keystoreDirs {
+		files, err := ioutil.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed reading keystore directory %s", dir)
+		}
+
+		for _, f := range files {
+			if f.IsDir() {
+				continue
+			}
+			path := filepath.Join(dir, f.Name())
+			found, err := containsPrivateKey(path)
+			if err != nil {
+				return err
+			}
+			if found {
+				return errors.Errorf("private key %s found on disk while peer.noKeysOnDisk is set", path)
+			}
+		}
+	}
+
+	return nil
+}
+
+// containsPrivateKey returns true if the file is named as a private key by the
+// file keystore or holds a PEM encoded private key.
+func containsPrivateKey(path string) (bool, error) {
+	if strings.HasSuffix(path, "_sk") || strings.HasSuffix(path, "_key") {
+		return true, nil
+	}
+
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed reading %s", path)
+	}
+	for {
+		var block *pem.Block
+		block, raw = pem.Decode(raw)
+		if block == nil {
+			return false, nil
+		}
+		if strings.HasSuffix(block.Type, "PRIVATE KEY") {
+			return true, nil
+		}
+	}
+}
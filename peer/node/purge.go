@@ -0,0 +1,56 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package node
+
+import (
+	"github.com/hyperledger/fabric/core/container/kubernetescontroller"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var purgeChaincodeName string
+
+func purgeChaincodeCmd() *cobra.Command {
+	nodePurgeChaincodeCmd.ResetFlags()
+	flags := nodePurgeChaincodeCmd.Flags()
+	flags.StringVarP(&purgeChaincodeName, "name", "n", "", "Name of the chaincode to purge. All chaincodes are purged when omitted.")
+
+	return nodePurgeChaincodeCmd
+}
+
+var nodePurgeChaincodeCmd = &cobra.Command{
+	Use:   "purge-chaincode",
+	Short: "Removes the kubernetes resources of chaincodes owned by the node.",
+	Long:  `Removes all chaincode pods, config maps and secrets labeled as owned by this peer from the kubernetes namespace, optionally limited to a single chaincode. This is intended for recovering from interrupted chaincode upgrades. Chaincodes which are still required will be relaunched by the peer on their next invocation.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		api, err := newPurgeAPI()
+		if err != nil {
+			return err
+		}
+
+		removed, err := api.Purge(purgeChaincodeName)
+		if err != nil {
+			return errors.WithMessage(err, "failed to purge chaincode resources")
+		}
+		logger.Infof("Purged %d chaincode resources from namespace %s", removed, api.Namespace)
+		return nil
+	},
+}
+
+// newPurgeAPI creates the kubernetes API of the peer, replaceable for testing
+var newPurgeAPI = func() (*kubernetescontroller.KubernetesAPI, error) {
+	if !kubernetescontroller.InCluster() {
+		return nil, errors.New("kubernetes support is disabled or the node is not running in a kubernetes cluster")
+	}
+
+	return kubernetescontroller.NewKubernetesAPI(
+		viper.GetString("peer.id"),
+		viper.GetString("peer.networkId"),
+		kubernetescontroller.NewExitHandles(),
+	)
+}
@@ -0,0 +1,61 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package node
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric/core/container/kubernetescontroller"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestPurgeChaincodeCmd(t *testing.T) {
+	t.Run("when kubernetes support is disabled", func(t *testing.T) {
+		viper.Set("vm.kubernetes.enabled", false)
+		cmd := purgeChaincodeCmd()
+		args := []string{"-n", "mycc"}
+		cmd.SetArgs(args)
+		err := cmd.Execute()
+		assert.EqualError(t, err, "kubernetes support is disabled or the node is not running in a kubernetes cluster")
+	})
+
+	t.Run("when a chaincode is named", func(t *testing.T) {
+		labels := func(ccname string) map[string]string {
+			return map[string]string{"peer-owner": "peer0", "ccname": ccname, "ccver": "1.0"}
+		}
+		client := fake.NewSimpleClientset(
+			&apiv1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "cc-peer0-mycc-1.0", Namespace: "ns", Labels: labels("mycc")}},
+			&apiv1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "cc-peer0-mycc-extra-1.0", Namespace: "ns", Labels: labels("mycc-extra")}},
+			&apiv1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "cc-peer0-mycc-1.0", Namespace: "ns", Labels: map[string]string{"peer-owner": "peer0", "peercc": "cc-peer0-mycc-1.0"}}},
+			&apiv1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "cc-peer0-mycc-extra-1.0", Namespace: "ns", Labels: map[string]string{"peer-owner": "peer0", "peercc": "cc-peer0-mycc-extra-1.0"}}},
+		)
+
+		defer func(orig func() (*kubernetescontroller.KubernetesAPI, error)) { newPurgeAPI = orig }(newPurgeAPI)
+		newPurgeAPI = func() (*kubernetescontroller.KubernetesAPI, error) {
+			return kubernetescontroller.NewKubernetesAPIForClient(client, "peer0", "ns", kubernetescontroller.NewExitHandles()), nil
+		}
+
+		cmd := purgeChaincodeCmd()
+		cmd.SetArgs([]string{"-n", "mycc"})
+		require.NoError(t, cmd.Execute())
+
+		pods, err := client.CoreV1().Pods("ns").List(metav1.ListOptions{})
+		require.NoError(t, err)
+		require.Len(t, pods.Items, 1)
+		assert.Equal(t, "cc-peer0-mycc-extra-1.0", pods.Items[0].Name)
+
+		configMaps, err := client.CoreV1().ConfigMaps("ns").List(metav1.ListOptions{})
+		require.NoError(t, err)
+		require.Len(t, configMaps.Items, 1)
+		assert.Equal(t, "cc-peer0-mycc-extra-1.0", configMaps.Items[0].Name)
+	})
+}
@@ -8,6 +8,7 @@ package node
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"net"
 	"net/http"
@@ -18,6 +19,7 @@ import (
 	"time"
 
 	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-lib-go/healthz"
 	"github.com/hyperledger/fabric/common/cauthdsl"
 	ccdef "github.com/hyperledger/fabric/common/chaincode"
 	"github.com/hyperledger/fabric/common/crypto"
@@ -30,6 +32,7 @@ import (
 	"github.com/hyperledger/fabric/common/localmsp"
 	"github.com/hyperledger/fabric/common/metadata"
 	"github.com/hyperledger/fabric/common/metrics"
+	"github.com/hyperledger/fabric/common/metrics/disabled"
 	"github.com/hyperledger/fabric/common/policies"
 	"github.com/hyperledger/fabric/common/viperutil"
 	"github.com/hyperledger/fabric/core/aclmgmt"
@@ -49,7 +52,9 @@ import (
 	"github.com/hyperledger/fabric/core/committer/txvalidator"
 	"github.com/hyperledger/fabric/core/common/ccprovider"
 	"github.com/hyperledger/fabric/core/common/privdata"
+	coreconfig "github.com/hyperledger/fabric/core/config"
 	"github.com/hyperledger/fabric/core/container"
+	_ "github.com/hyperledger/fabric/core/container/builtin"
 	"github.com/hyperledger/fabric/core/container/dockercontroller"
 	"github.com/hyperledger/fabric/core/container/inproccontroller"
 	"github.com/hyperledger/fabric/core/endorser"
@@ -139,6 +144,10 @@ func serve(args []string) error {
 		panic("Unsupported msp type " + msp.ProviderTypeToString(mspType))
 	}
 
+	if err := enforceNoKeysOnDisk(); err != nil {
+		return err
+	}
+
 	// Trace RPCs with the golang.org/x/net/trace package. This was moved out of
 	// the deliver service connection factory as it has process wide implications
 	// and was racy with respect to initialization of gRPC clients and servers.
@@ -325,6 +334,21 @@ func serve(args []string) error {
 		time.Now(),
 		time.AfterFunc)
 
+	expirationCerts := crypto.ExpirationCertificates(
+		serverConfig.SecOpts.UseTLS,
+		serverConfig.SecOpts.Certificate,
+		comm.GetCredentialSupport().GetClientCertificate().Certificate,
+		serializedIdentity)
+	caCerts, err := localMSPCACertificates(coreconfig.GetPath("peer.mspConfigPath"), viper.GetString("peer.localMspId"))
+	if err != nil {
+		expirationLogger.Warningf("Failed loading local MSP CA certificates, their expiration is not reported: %s", err)
+	}
+	expirationCerts = append(expirationCerts, caCerts...)
+	if viper.GetDuration("peer.tls.certReloadInterval") > 0 {
+		crypto.ReloadedFrom(expirationCerts, "server_tls", coreconfig.GetPath("peer.tls.cert.file"))
+	}
+	go crypto.NewExpirationReporter(metricsProvider, expirationCerts).Run(time.Hour, nil)
+
 	policyMgr := peer.NewChannelPolicyManagerGetter()
 
 	// Initialize gossip component
@@ -682,15 +706,30 @@ func registerChaincodeSupport(
 		viper.GetString("peer.networkId"),
 		ops.Provider,
 	)
-	dockerVM := dockercontroller.NewDockerVM(
-		dockerProvider.PeerID,
-		dockerProvider.NetworkID,
-		dockerProvider.BuildMetrics,
-	)
+	// The kubernetes API is connected only once chaincode is launched, so the
+	// docker daemon remains the health check of the kubernetes controller. The
+	// health check builds no images, so its build metrics are not reported.
+	checkerName := dockerProvider.Controller()
+	var checker healthz.HealthChecker
+	if checkerName == "kubernetes" {
+		checkerName = "docker"
+		checker = dockercontroller.NewDockerVM(
+			dockerProvider.PeerID,
+			dockerProvider.NetworkID,
+			dockercontroller.NewBuildMetrics(&disabled.Provider{}),
+		)
+	} else {
+		// An unknown or misconfigured controller would otherwise only show as a failing health check.
+		vm, err := dockerProvider.NewControllerVM()
+		if err != nil {
+			logger.Panicf("failed to create chaincode controller %s: %s", checkerName, err)
+		}
+		checker = vm
+	}
 
-	err := ops.RegisterChecker("docker", dockerVM)
+	err := ops.RegisterChecker(checkerName, checker)
 	if err != nil {
-		logger.Panicf("failed to register docker health check: %s", err)
+		logger.Panicf("failed to register %s health check: %s", checkerName, err)
 	}
 
 	chaincodeSupport := chaincode.NewChaincodeSupport(
@@ -714,6 +753,7 @@ func registerChaincodeSupport(
 		ops.Provider,
 	)
 	ipRegistry.ChaincodeSupport = chaincodeSupport
+	dockerProvider.Relaunch = chaincodeSupport.Relaunch
 	ccp := chaincode.NewProvider(chaincodeSupport)
 
 	ccSrv := pb.ChaincodeSupportServer(chaincodeSupport)
@@ -883,6 +923,10 @@ func initGossipService(policyMgr policies.ChannelPolicyManagerGetter, metricsPro
 		certs = &gossipcommon.TLSCertificates{}
 		certs.TLSServerCert.Store(&serverCert)
 		certs.TLSClientCert.Store(&clientCert)
+
+		if interval := viper.GetDuration("peer.tls.certReloadInterval"); interval > 0 {
+			go watchServerCertificate(peerServer, certs, interval)
+		}
 	}
 
 	messageCryptoService := peergossip.NewMCS(
@@ -906,6 +950,26 @@ func initGossipService(policyMgr policies.ChannelPolicyManagerGetter, metricsPro
 	)
 }
 
+// watchServerCertificate loads the TLS server certificate and key whenever
+// their files change, updating both the peer server and the certificate gossip
+// binds its connections to. The client certificate is not reloaded.
+func watchServerCertificate(peerServer *comm.GRPCServer, certs *gossipcommon.TLSCertificates, interval time.Duration) {
+	update := func(cert tls.Certificate) {
+		peerServer.SetServerCertificate(cert)
+		certs.TLSServerCert.Store(&cert)
+	}
+	comm.WatchKeyPair(coreconfig.GetPath("peer.tls.cert.file"), coreconfig.GetPath("peer.tls.key.file"), interval, update, nil)
+}
+
+// localMSPCACertificates returns the CA certificates of the local MSP.
+func localMSPCACertificates(dir, mspID string) ([]crypto.RoleCertificate, error) {
+	mspConfig, err := msp.GetVerifyingMspConfig(dir, mspID, msp.ProviderTypeToString(msp.FABRIC))
+	if err != nil {
+		return nil, err
+	}
+	return crypto.MSPCACertificates(mspConfig)
+}
+
 func newOperationsSystem() *operations.System {
 	return operations.NewSystem(operations.Options{
 		Logger:        flogging.MustGetLogger("peer.operations"),
@@ -925,6 +989,7 @@ func newOperationsSystem() *operations.System {
 			KeyFile:            viper.GetString("operations.tls.key.file"),
 			ClientCertRequired: viper.GetBool("operations.tls.clientAuthRequired"),
 			ClientCACertFiles:  viper.GetStringSlice("operations.tls.clientRootCAs.files"),
+			CertReloadInterval: viper.GetDuration("operations.tls.certReloadInterval"),
 		},
 		Version: metadata.Version,
 	})